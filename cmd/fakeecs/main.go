@@ -0,0 +1,74 @@
+// fakeecs serves a fake ECS Task Metadata Endpoint for local development.
+//
+// Run it, then point the sidecar at it:
+//
+//	fakeecs -listen :8080 -scenario rising-cpu
+//	ECS_CONTAINER_METADATA_URI_V4=http://localhost:8080/v4/fake taskmetadata-cloudwatch
+//
+// With -docker-socket it also serves the Docker Engine API of the same task on
+// a unix socket, for the docker source.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	scenarioName := flag.String("scenario", "steady",
+		"synthesized scenario to serve: "+strings.Join(fakeecs.ScenarioNames(), ", "))
+	fixturesDir := flag.String("fixtures", "",
+		"directory of recorded task.json and stats*.json to serve instead of a synthesized scenario")
+	dockerSocket := flag.String("docker-socket", "", "path of a unix socket to also serve the Docker Engine API on")
+	dockerInterval := flag.Duration("docker-interval", 500*time.Millisecond, "interval of the Docker stats samples")
+	flag.Parse()
+
+	var scenario fakeecs.Scenario
+	if *fixturesDir != "" {
+		s, err := fakeecs.LoadFixtures(*fixturesDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to load fixtures: %v\n", err)
+			os.Exit(1)
+		}
+		scenario = s
+	} else {
+		newScenario, ok := fakeecs.Scenarios[*scenarioName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown scenario %q, must be one of: %s\n",
+				*scenarioName, strings.Join(fakeecs.ScenarioNames(), ", "))
+			os.Exit(1)
+		}
+		scenario = newScenario()
+	}
+
+	if *dockerSocket != "" {
+		os.Remove(*dockerSocket)
+		l, err := net.Listen("unix", *dockerSocket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to listen on %s: %v\n", *dockerSocket, err)
+			os.Exit(1)
+		}
+		fmt.Printf("serving fake Docker Engine API on %s\n", *dockerSocket)
+		go func() {
+			if err := http.Serve(l, fakeecs.NewDockerServer(scenario, *dockerInterval)); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to serve: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	fmt.Printf("serving fake task metadata endpoint on %s\n", *listen)
+	if err := http.ListenAndServe(*listen, fakeecs.NewServer(scenario)); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to serve: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cw"
+)
+
+// runCompare implements the compare command and returns the exit code: 0 if
+// the candidate revision passes, 1 otherwise
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	namespace := fs.String("namespace", config.Default().Namespace, "CloudWatch namespace the metrics were published to")
+	family := fs.String("family", "", "family of the task definition")
+	container := fs.String("container", "", "name of the container in the task definition")
+	baseline := fs.String("baseline", "", "revision to compare against, e.g. the one being replaced")
+	candidate := fs.String("candidate", "", "revision being rolled out")
+	since := fs.Duration("since", time.Hour, "how far back to compute the averages from")
+	threshold := fs.Float64("threshold", 10,
+		"maximum increase of the candidate's average utilization over the baseline's, in percentage points")
+	region := fs.String("region", "", "AWS region of the metrics, defaults to the one of the environment")
+	fs.Parse(args)
+
+	if *family == "" || *container == "" || *baseline == "" || *candidate == "" {
+		fmt.Fprintln(os.Stderr, "-family, -container, -baseline and -candidate are required")
+		fs.Usage()
+		return 1
+	}
+
+	cfg := aws.NewConfig()
+	if *region != "" {
+		cfg.Region = region
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create the AWS session: %v\n", err)
+		return 1
+	}
+
+	end := time.Now()
+	comparisons, err := cw.CompareRevisions(context.Background(), cloudwatch.New(sess), cw.CompareInput{
+		Namespace: *namespace,
+		Family:    *family,
+		Container: *container,
+		Baseline:  *baseline,
+		Candidate: *candidate,
+		Start:     end.Add(-*since),
+		End:       end,
+		Threshold: *threshold,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	code := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "METRIC\t%s:%s\t%s:%s\tDELTA\tRESULT\n", *family, *baseline, *family, *candidate)
+	for _, c := range comparisons {
+		result := "pass"
+		switch {
+		case c.Baseline == nil || c.Candidate == nil:
+			result = "fail (no data)"
+		case !c.Pass:
+			result = fmt.Sprintf("fail (> %+.2f)", *threshold)
+		}
+		if !c.Pass {
+			code = 1
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%+.2f\t%s\n", c.MetricName, percent(c.Baseline), percent(c.Candidate), c.Delta(), result)
+	}
+	w.Flush()
+	return code
+}
+
+func percent(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", *v)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+)
+
+// configFlags are the command line flags. They override the configuration
+// file, but only when they are set explicitly.
+type configFlags struct {
+	fs     *flag.FlagSet
+	path   string
+	values *config.Config
+}
+
+func newConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{fs: fs, values: config.Default()}
+	v := f.values
+	fs.StringVar(&f.path, "config", "",
+		"path of the JSON or YAML configuration file, defaults to $"+config.FileEnvVar+" then the content of $"+config.InlineEnvVar)
+	fs.StringVar(&v.Mode, "mode", v.Mode,
+		"task to report the task the sidecar runs in, or daemon to report every task of the EC2 container instance")
+	fs.DurationVar((*time.Duration)(&v.Interval), "interval", v.Interval.Duration(),
+		"how often the stats are collected and published")
+	fs.StringVar(&v.Namespace, "namespace", v.Namespace,
+		"CloudWatch namespace of the metrics, the sidecar's own metrics go to <namespace>/Publisher")
+	fs.DurationVar((*time.Duration)(&v.StartupTimeout), "startup-timeout", v.StartupTimeout.Duration(),
+		"maximum time to wait for the task to become RUNNING, 0 waits forever")
+	fs.DurationVar((*time.Duration)(&v.ShutdownTimeout), "shutdown-timeout", v.ShutdownTimeout.Duration(),
+		"maximum time to flush the final metrics on SIGTERM, keep it under the ECS stop timeout (30s by default)")
+	fs.StringVar(&v.Listen, "listen", v.Listen,
+		"address to serve the health, Prometheus and debug endpoints on, e.g. :8081. Disabled if empty")
+	fs.DurationVar((*time.Duration)(&v.ReadyMaxPublishAge), "ready-max-publish-age", v.ReadyMaxPublishAge.Duration(),
+		"/readyz fails when no publish succeeded for this long, 0 disables the check")
+	fs.BoolVar(&v.SelfMetrics, "self-metrics", v.SelfMetrics,
+		"also put the sidecar's own metrics to CloudWatch under the <namespace>/Publisher namespace")
+	fs.BoolVar(&v.DryRun.Enabled, "dry-run", v.DryRun.Enabled,
+		"write the PutMetricData requests as JSON lines instead of sending them")
+	fs.StringVar(&v.DryRun.Path, "dry-run-path", v.DryRun.Path,
+		"file the dry run appends the requests to, standard output if empty")
+	fs.StringVar(&v.Log.Level, "log-level", v.Log.Level, "minimum level of the logs: debug, info, warn or error")
+	fs.StringVar(&v.Log.Format, "log-format", v.Log.Format, "format of the logs: text or json")
+	return f
+}
+
+// load returns the configuration with the flags set applied over it. It is
+// called again to reload the configuration.
+func (f *configFlags) load() (*config.Config, error) {
+	cfg, err := config.Load(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := f.values
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "mode":
+			cfg.Mode = v.Mode
+		case "interval":
+			cfg.Interval = v.Interval
+		case "namespace":
+			cfg.Namespace = v.Namespace
+		case "startup-timeout":
+			cfg.StartupTimeout = v.StartupTimeout
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = v.ShutdownTimeout
+		case "listen":
+			cfg.Listen = v.Listen
+		case "ready-max-publish-age":
+			cfg.ReadyMaxPublishAge = v.ReadyMaxPublishAge
+		case "self-metrics":
+			cfg.SelfMetrics = v.SelfMetrics
+		case "dry-run":
+			cfg.DryRun.Enabled = v.DryRun.Enabled
+		case "dry-run-path":
+			cfg.DryRun.Path = v.DryRun.Path
+		case "log-level":
+			cfg.Log.Level = v.Log.Level
+		case "log-format":
+			cfg.Log.Format = v.Log.Format
+		}
+	})
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, &config.ValidationError{Source: "(with command line flags)", Errors: errs}
+	}
+	return cfg, nil
+}
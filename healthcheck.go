@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/server"
+)
+
+// runHealthcheck implements the healthcheck command and returns the exit code.
+// Unless -url is given, the endpoint is found from the listen address of the
+// configuration, which the command reads the way the sidecar does.
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+	url := fs.String("url", "", "endpoint to check, it must respond 200 OK. Defaults to /readyz on the configured listen address")
+	timeout := fs.Duration("timeout", 3*time.Second, "maximum time to wait for the response")
+	fs.Parse(args)
+
+	if *url == "" {
+		cfg, err := flags.load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+			return 1
+		}
+		if cfg.Listen == "" {
+			fmt.Fprintln(os.Stderr, "unhealthy: listen is not configured, set it or pass -url")
+			return 1
+		}
+		*url, err = readyURL(cfg.Listen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+			return 1
+		}
+	}
+
+	if err := server.Check(*url, *timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readyURL returns the URL of /readyz served on the listen address, through
+// localhost if it listens on every interface
+func readyURL(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse the listen address %q: %v", listen, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/readyz", nil
+}
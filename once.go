@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/collector"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cw"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// warmUp is how long to wait for a second sample with the docker and cgroup
+// sources, whose first one has no previous CPU usage to compute a rate from
+const warmUp = 2 * time.Second
+
+// onceFormats are the output formats of the once command. The properties
+// mapped from tags are only written by the emf format.
+var onceFormats = map[string]func(w io.Writer, d collector.Data, properties map[string]string) error{
+	"table": writeTable,
+	"json":  writeJSON,
+	"emf":   writeEMF,
+}
+
+// runOnce implements the once command, which collects a single sample the way
+// the sidecar does, prints its metric data and returns the exit code
+func runOnce(args []string) int {
+	fs := flag.NewFlagSet("once", flag.ExitOnError)
+	flags := newConfigFlags(fs)
+	format := fs.String("format", "table", "output format of the metrics: table, json or emf")
+	publish := fs.Bool("publish", false, "also put the metrics to the configured sinks")
+	fs.Parse(args)
+
+	write, ok := onceFormats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q, must be one of table, json, emf\n", *format)
+		return 1
+	}
+	cfg, err := flags.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := initLogger(cfg.Log.Level, cfg.Log.Format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	source := newSource(cfg)
+	if closer, ok := source.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
+	readiness := ecs.NewReadiness(source, cfg.StartupTimeout.Duration())
+	if err := readiness.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to wait for the task to be ready: %v\n", err)
+		return 1
+	}
+	task := currentTask(ctx, source, readiness.Task())
+
+	d, err := collectOnce(ctx, collector.New(source, cfg), cfg, task)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get task stats: %v\n", err)
+		return 1
+	}
+	if *publish {
+		svc, err := newCloudWatch(task)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		sinks, _ := newSinks(svc, cfg, task)
+		putData(ctx, sinks, d)
+		flushSinks(ctx, sinks.All()...)
+	}
+	if err := write(os.Stdout, d, collector.TagProperties(cfg, task)); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write the metrics: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// collectOnce collects the metric data of task, waiting for a second sample
+// when the first one can't have a CPU rate
+func collectOnce(ctx context.Context, c *collector.Collector, cfg *config.Config, task *ecs.TaskResponse) (collector.Data, error) {
+	if cfg.Mode == config.ModeDaemon || cfg.Source.Type == config.SourceMetadata {
+		return c.Collect(ctx, task)
+	}
+	if _, err := c.Collect(ctx, task); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(warmUp):
+	}
+	return c.Collect(ctx, task)
+}
+
+// namespaces returns the namespaces of d in a stable order
+func namespaces(d collector.Data) []string {
+	names := make([]string, 0, len(d))
+	for namespace := range d {
+		names = append(names, namespace)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeTable(w io.Writer, d collector.Data, _ map[string]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tMETRIC\tVALUE\tUNIT\tDIMENSIONS")
+	for _, namespace := range namespaces(d) {
+		for _, datum := range d[namespace] {
+			dims := make([]string, 0, len(datum.Dimensions))
+			for _, dim := range datum.Dimensions {
+				dims = append(dims, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%.2f\t%s\t%s\n", namespace, aws.StringValue(datum.MetricName),
+				aws.Float64Value(datum.Value), aws.StringValue(datum.Unit), strings.Join(dims, ","))
+		}
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, d collector.Data, _ map[string]string) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func writeEMF(w io.Writer, d collector.Data, properties map[string]string) error {
+	for _, namespace := range namespaces(d) {
+		if err := cw.WriteEMF(w, namespace, d[namespace], properties); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package cgroup reads the stats of containers straight from the cgroup
+// filesystem, cgroup v1 or v2, into the types.Stats shape of the Docker Engine
+// API, so that the same calculators apply whatever the source.
+
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	// DefaultRoot is where the cgroup filesystem is mounted
+	DefaultRoot = "/sys/fs/cgroup"
+	// DefaultProcRoot is where the proc filesystem is mounted
+	DefaultProcRoot = "/proc"
+
+	// maxSearchDepth is how deep under a hierarchy the cgroup of a container
+	// is searched for, e.g. ecs/<task id>/<container id>
+	maxSearchDepth = 4
+)
+
+// Reader reads the stats of containers from the cgroup filesystem mounted at
+// Root, and the host CPU and memory totals from the proc filesystem at
+// ProcRoot
+type Reader struct {
+	Root     string
+	ProcRoot string
+
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+// NewReader returns a Reader of the cgroup and proc filesystems mounted at
+// root and procRoot
+func NewReader(root, procRoot string) *Reader {
+	return &Reader{Root: root, ProcRoot: procRoot, paths: make(map[string]string)}
+}
+
+// Unified returns true if Root is a cgroup v2 unified hierarchy
+func (r *Reader) Unified() bool {
+	_, err := os.Stat(filepath.Join(r.Root, "cgroup.controllers"))
+	return err == nil
+}
+
+// Stats returns the stats of the container id. PreCPUStats is left empty, it
+// is up to the caller to keep the previous sample.
+func (r *Reader) Stats(id string) (*types.Stats, error) {
+	stats := &types.Stats{Read: time.Now()}
+	var err error
+	if r.Unified() {
+		err = r.readV2(id, stats)
+	} else {
+		err = r.readV1(id, stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.readHost(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readHost sets the host CPU usage and count, and caps the memory limit of an
+// unlimited container to the host memory the way Docker does
+func (r *Reader) readHost(stats *types.Stats) error {
+	usage, cpus, err := readProcStat(filepath.Join(r.ProcRoot, "stat"))
+	if err != nil {
+		return err
+	}
+	stats.CPUStats.SystemUsage = usage
+	stats.CPUStats.OnlineCPUs = cpus
+
+	total, err := readMemTotal(filepath.Join(r.ProcRoot, "meminfo"))
+	if err != nil {
+		return err
+	}
+	if stats.MemoryStats.Limit == 0 || stats.MemoryStats.Limit > total {
+		stats.MemoryStats.Limit = total
+	}
+	return nil
+}
+
+// dir returns the cgroup directory of the container id in the hierarchy
+// mounted at root, searching for it the first time. Docker names it after the
+// container ID, prefixed with "docker-" and suffixed with ".scope" by the
+// systemd cgroup driver, under a parent depending on the setup: ecs/<task id>
+// with the ECS agent, docker or system.slice.
+func (r *Reader) dir(root, id string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rel, ok := r.paths[id]; ok {
+		if _, err := os.Stat(filepath.Join(root, rel)); err == nil {
+			return filepath.Join(root, rel), nil
+		}
+	}
+
+	names := map[string]bool{id: true, "docker-" + id + ".scope": true}
+	var found string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case found != "":
+			return filepath.SkipDir
+		case err != nil || !info.IsDir():
+			// Unreadable directories are skipped, files ignored
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if names[info.Name()] {
+			found = rel
+			return filepath.SkipDir
+		}
+		if rel != "." && strings.Count(rel, string(filepath.Separator)) >= maxSearchDepth-1 {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to search the cgroup of %s: %v", id, err)
+	}
+	if found == "" {
+		return "", fmt.Errorf("unable to find the cgroup of %s under %s", id, root)
+	}
+	r.paths[id] = found
+	return filepath.Join(root, found), nil
+}
+
+// readUint returns the number in the file at path. "max" is returned as 0.
+func readUint(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	return v, nil
+}
+
+// readKeyValues returns the "key value" lines of the file at path, such as
+// memory.stat or cpu.stat
+func readKeyValues(path string) (map[string]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+		}
+		values[fields[0]] = v
+	}
+	return values, nil
+}
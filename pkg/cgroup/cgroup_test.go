@@ -0,0 +1,133 @@
+package cgroup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// hostMemory is the MemTotal of testdata/*/proc/meminfo, in bytes
+const hostMemory = 8000000 * 1024
+
+func newTestReader(version string) *Reader {
+	return NewReader("testdata/"+version+"/sys/fs/cgroup", "testdata/"+version+"/proc")
+}
+
+func blkioEntries(read, write string, readValue, writeValue uint64) []types.BlkioStatEntry {
+	return []types.BlkioStatEntry{
+		{Major: 8, Minor: 0, Op: read, Value: readValue},
+		{Major: 8, Minor: 0, Op: write, Value: writeValue},
+	}
+}
+
+func TestStats(t *testing.T) {
+	cases := []struct {
+		version string
+		id      string
+		unified bool
+
+		totalUsage, userUsage, kernelUsage uint64
+		percpu                             int
+		memoryUsage, memoryLimit           uint64
+		// bytes and ios are the Read and Write entries expected in the block
+		// I/O stats, the other entries are ignored
+		bytes, ios []types.BlkioStatEntry
+	}{
+		{
+			version: "v1", id: "app-0",
+			totalUsage: 250e9, userUsage: 200e9, kernelUsage: 50e9, percpu: 2,
+			memoryUsage: 256 << 20, memoryLimit: 512 << 20,
+			// blkio.io_service_bytes_recursive is empty without the CFQ
+			// scheduler, the throttle files are read instead
+			bytes: blkioEntries("Read", "Write", 1<<20, 2<<20),
+			ios:   blkioEntries("Read", "Write", 16, 32),
+		},
+		{
+			version: "v1", id: "taskmetadata-cloudwatch-0",
+			totalUsage: 10e9, userUsage: 8e9, kernelUsage: 2e9, percpu: 2,
+			// The unlimited memory.limit_in_bytes is capped to the host memory
+			memoryUsage: 8 << 20, memoryLimit: hostMemory,
+			bytes: blkioEntries("Read", "Write", 1<<20, 2<<20),
+			ios:   blkioEntries("Read", "Write", 16, 32),
+		},
+		{
+			version: "v2", id: "app-0", unified: true,
+			totalUsage: 250e9, userUsage: 200e9, kernelUsage: 50e9,
+			memoryUsage: 256 << 20, memoryLimit: 512 << 20,
+			// io.stat's rbytes, wbytes, rios and wios
+			bytes: blkioEntries("read", "write", 1<<20, 2<<20),
+			ios:   blkioEntries("read", "write", 16, 32),
+		},
+		{
+			version: "v2", id: "taskmetadata-cloudwatch-0", unified: true,
+			totalUsage: 10e9, userUsage: 8e9, kernelUsage: 2e9,
+			// memory.max is "max"
+			memoryUsage: 8 << 20, memoryLimit: hostMemory,
+			bytes: blkioEntries("read", "write", 1<<20, 2<<20),
+			ios:   blkioEntries("read", "write", 16, 32),
+		},
+	}
+
+	for _, c := range cases {
+		name := c.version + "/" + c.id
+		r := newTestReader(c.version)
+		if r.Unified() != c.unified {
+			t.Errorf("%s: Unified = %v, want %v", name, r.Unified(), c.unified)
+		}
+		stats, err := r.Stats(c.id)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		cpu := stats.CPUStats
+		if cpu.CPUUsage.TotalUsage != c.totalUsage || cpu.CPUUsage.UsageInUsermode != c.userUsage || cpu.CPUUsage.UsageInKernelmode != c.kernelUsage {
+			t.Errorf("%s: got CPU usage %+v", name, cpu.CPUUsage)
+		}
+		if len(cpu.CPUUsage.PercpuUsage) != c.percpu {
+			t.Errorf("%s: got %d per-CPU usages, want %d", name, len(cpu.CPUUsage.PercpuUsage), c.percpu)
+		}
+		// From testdata/*/proc/stat
+		if cpu.SystemUsage != 1000e9 || cpu.OnlineCPUs != 2 {
+			t.Errorf("%s: got system usage %d and %d online CPUs", name, cpu.SystemUsage, cpu.OnlineCPUs)
+		}
+
+		if stats.MemoryStats.Usage != c.memoryUsage {
+			t.Errorf("%s: memory usage = %d, want %d", name, stats.MemoryStats.Usage, c.memoryUsage)
+		}
+		if stats.MemoryStats.Limit != c.memoryLimit {
+			t.Errorf("%s: memory limit = %d, want %d", name, stats.MemoryStats.Limit, c.memoryLimit)
+		}
+
+		if got := readWrite(stats.BlkioStats.IoServiceBytesRecursive); !reflect.DeepEqual(got, c.bytes) {
+			t.Errorf("%s: got service bytes %+v, want %+v", name, got, c.bytes)
+		}
+		if got := readWrite(stats.BlkioStats.IoServicedRecursive); !reflect.DeepEqual(got, c.ios) {
+			t.Errorf("%s: got serviced %+v, want %+v", name, got, c.ios)
+		}
+		if stats.PidsStats.Current != 7 {
+			t.Errorf("%s: got %d pids, want 7", name, stats.PidsStats.Current)
+		}
+	}
+}
+
+func TestStatsUnknownContainer(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		if _, err := newTestReader(version).Stats("unknown-0"); err == nil {
+			t.Errorf("%s: no error for an unknown container", version)
+		}
+	}
+}
+
+// readWrite returns the Read and Write entries of entries, whatever their case
+func readWrite(entries []types.BlkioStatEntry) []types.BlkioStatEntry {
+	var rw []types.BlkioStatEntry
+	for _, e := range entries {
+		switch e.Op {
+		case "Read", "read", "Write", "write":
+			rw = append(rw, e)
+		}
+	}
+	return rw
+}
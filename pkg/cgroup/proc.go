@@ -0,0 +1,67 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the unit of /proc/stat, in ticks per second
+const clockTicks = userHZ
+
+// readProcStat returns the CPU time of the host in nanoseconds, summed the way
+// Docker computes system_cpu_usage, and the number of CPUs, from the /proc/stat
+// file at path
+func readProcStat(path string) (usage uint64, cpus uint32, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "cpu":
+			// user, nice, system, idle, iowait, irq and softirq
+			if len(fields) < 8 {
+				return 0, 0, fmt.Errorf("unable to parse %s: %q", path, line)
+			}
+			var ticks uint64
+			for _, f := range fields[1:8] {
+				v, err := strconv.ParseUint(f, 10, 64)
+				if err != nil {
+					return 0, 0, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+				}
+				ticks += v
+			}
+			usage = ticks * 1e9 / clockTicks
+		case strings.HasPrefix(fields[0], "cpu"):
+			cpus++
+		}
+	}
+	if usage == 0 {
+		return 0, 0, fmt.Errorf("unable to parse %s: no cpu line", path)
+	}
+	return usage, cpus, nil
+}
+
+// readMemTotal returns the memory of the host in bytes, from the /proc/meminfo
+// file at path
+func readMemTotal(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to parse %s: no MemTotal", path)
+}
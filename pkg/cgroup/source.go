@@ -0,0 +1,84 @@
+package cgroup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+// Source is an ecs.MetadataSource reading the task from Metadata and the stats
+// of its containers from the cgroup filesystem. The previous sample of each
+// container is kept to fill PreCPUStats, as Docker does.
+type Source struct {
+	Metadata ecs.MetadataSource
+	Reader   *Reader
+
+	mu   sync.Mutex
+	task *ecs.TaskResponse
+	last map[string]*types.Stats
+}
+
+// NewSource returns a Source reading the task described by metadata with
+// reader
+func NewSource(metadata ecs.MetadataSource, reader *Reader) *Source {
+	return &Source{Metadata: metadata, Reader: reader, last: make(map[string]*types.Stats)}
+}
+
+// TaskMetadata implements ecs.MetadataSource
+func (s *Source) TaskMetadata(ctx context.Context) (*ecs.TaskResponse, error) {
+	task, err := s.Metadata.TaskMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.task = task
+	s.mu.Unlock()
+	return task, nil
+}
+
+// TaskStats implements ecs.MetadataSource. It returns the stats of the
+// running containers of the task last returned by TaskMetadata, leaving out
+// the ones whose cgroup can't be read.
+func (s *Source) TaskStats(ctx context.Context) (map[string]*types.Stats, error) {
+	s.mu.Lock()
+	task := s.task
+	s.mu.Unlock()
+	if task == nil {
+		var err error
+		if task, err = s.TaskMetadata(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	containers := ecs.RunningContainers(task)
+	stats := make(map[string]*types.Stats, len(containers))
+	var firstErr error
+	for _, con := range containers {
+		st, err := s.Reader.Stats(con.ID)
+		if err != nil {
+			logger.With(logger.Fields{"container": con.DockerName}).Debugf("unable to read the container cgroup: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if prev, ok := s.last[con.ID]; ok {
+			st.PreRead = prev.Read
+			st.PreCPUStats = prev.CPUStats
+		}
+		stats[con.ID] = st
+	}
+	s.last = stats
+
+	// Only fail when no cgroup at all can be read, e.g. a wrong root
+	if len(stats) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return stats, nil
+}
@@ -0,0 +1,145 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// userHZ is the unit of cpuacct.stat, in ticks per second
+const userHZ = 100
+
+// readV1 reads the stats of the container id from the cgroup v1 hierarchies,
+// one per controller
+func (r *Reader) readV1(id string, stats *types.Stats) error {
+	cpuacct, err := r.dir(r.controller("cpuacct", "cpu,cpuacct"), id)
+	if err != nil {
+		return err
+	}
+	usage := &stats.CPUStats.CPUUsage
+	if usage.TotalUsage, err = readUint(filepath.Join(cpuacct, "cpuacct.usage")); err != nil {
+		return err
+	}
+	if usage.PercpuUsage, err = readUints(filepath.Join(cpuacct, "cpuacct.usage_percpu")); err != nil {
+		return err
+	}
+	acct, err := readKeyValues(filepath.Join(cpuacct, "cpuacct.stat"))
+	if err != nil {
+		return err
+	}
+	usage.UsageInUsermode = acct["user"] * 1e9 / userHZ
+	usage.UsageInKernelmode = acct["system"] * 1e9 / userHZ
+	// cpu.stat is in the cpu controller, usually mounted along with cpuacct
+	if throttling, err := readKeyValues(filepath.Join(cpuacct, "cpu.stat")); err == nil {
+		stats.CPUStats.ThrottlingData = types.ThrottlingData{
+			Periods:          throttling["nr_periods"],
+			ThrottledPeriods: throttling["nr_throttled"],
+			ThrottledTime:    throttling["throttled_time"],
+		}
+	}
+
+	memory, err := r.dir(r.controller("memory"), id)
+	if err != nil {
+		return err
+	}
+	mem := &stats.MemoryStats
+	for file, v := range map[string]*uint64{
+		"memory.usage_in_bytes":     &mem.Usage,
+		"memory.max_usage_in_bytes": &mem.MaxUsage,
+		"memory.failcnt":            &mem.Failcnt,
+		"memory.limit_in_bytes":     &mem.Limit,
+	} {
+		if *v, err = readUint(filepath.Join(memory, file)); err != nil {
+			return err
+		}
+	}
+	if mem.Stats, err = readKeyValues(filepath.Join(memory, "memory.stat")); err != nil {
+		return err
+	}
+
+	// blkio and pids are optional, like in Docker
+	if blkio, err := r.dir(r.controller("blkio"), id); err == nil {
+		stats.BlkioStats.IoServiceBytesRecursive, _ = readBlkio(blkio, "io_service_bytes_recursive")
+		stats.BlkioStats.IoServicedRecursive, _ = readBlkio(blkio, "io_serviced_recursive")
+	}
+	if pids, err := r.dir(r.controller("pids"), id); err == nil {
+		stats.PidsStats.Current, _ = readUint(filepath.Join(pids, "pids.current"))
+		stats.PidsStats.Limit, _ = readUint(filepath.Join(pids, "pids.max"))
+	}
+	return nil
+}
+
+// controller returns the hierarchy of the first of names mounted under Root
+func (r *Reader) controller(names ...string) string {
+	for _, name := range names {
+		path := filepath.Join(r.Root, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(r.Root, names[0])
+}
+
+// readBlkio returns the entries of blkio.<name>, or of blkio.throttle.<name>
+// if the former is empty, which it is with the blk-mq schedulers
+func readBlkio(dir, name string) ([]types.BlkioStatEntry, error) {
+	var entries []types.BlkioStatEntry
+	for _, file := range []string{"blkio." + name, "blkio.throttle." + name} {
+		var err error
+		if entries, err = readBlkioFile(filepath.Join(dir, file)); err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// readBlkioFile parses the "major:minor op value" lines of the file at path,
+// leaving out the total
+func readBlkioFile(path string) ([]types.BlkioStatEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	var entries []types.BlkioStatEntry
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		var e types.BlkioStatEntry
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &e.Major, &e.Minor); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+		}
+		if e.Value, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+		}
+		e.Op = fields[1]
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// readUints returns the space separated numbers in the file at path
+func readUints(path string) ([]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	var values []uint64
+	for _, f := range strings.Fields(string(b)) {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
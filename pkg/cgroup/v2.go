@@ -0,0 +1,110 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// readV2 reads the stats of the container id from the cgroup v2 unified
+// hierarchy. cgroup v2 has no per-CPU usage, so PercpuUsage is left empty.
+func (r *Reader) readV2(id string, stats *types.Stats) error {
+	dir, err := r.dir(r.Root, id)
+	if err != nil {
+		return err
+	}
+
+	cpu, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return err
+	}
+	// cpu.stat is in microseconds, Docker stats in nanoseconds
+	stats.CPUStats.CPUUsage = types.CPUUsage{
+		TotalUsage:        cpu["usage_usec"] * 1000,
+		UsageInUsermode:   cpu["user_usec"] * 1000,
+		UsageInKernelmode: cpu["system_usec"] * 1000,
+	}
+	stats.CPUStats.ThrottlingData = types.ThrottlingData{
+		Periods:          cpu["nr_periods"],
+		ThrottledPeriods: cpu["nr_throttled"],
+		ThrottledTime:    cpu["throttled_usec"] * 1000,
+	}
+
+	mem := &stats.MemoryStats
+	if mem.Usage, err = readUint(filepath.Join(dir, "memory.current")); err != nil {
+		return err
+	}
+	// "max" means unlimited, capped to the host memory by readHost
+	if mem.Limit, err = readUint(filepath.Join(dir, "memory.max")); err != nil {
+		return err
+	}
+	if mem.Stats, err = readKeyValues(filepath.Join(dir, "memory.stat")); err != nil {
+		return err
+	}
+	// memory.peak only exists since Linux 5.19
+	if peak, err := readUint(filepath.Join(dir, "memory.peak")); err == nil {
+		mem.MaxUsage = peak
+	}
+
+	// io and pids are optional, like in Docker
+	if _, err := os.Stat(filepath.Join(dir, "io.stat")); err == nil {
+		bytes, ios, err := readIOStat(filepath.Join(dir, "io.stat"))
+		if err != nil {
+			return err
+		}
+		stats.BlkioStats.IoServiceBytesRecursive = bytes
+		stats.BlkioStats.IoServicedRecursive = ios
+	}
+	if current, err := readUint(filepath.Join(dir, "pids.current")); err == nil {
+		stats.PidsStats.Current = current
+		stats.PidsStats.Limit, _ = readUint(filepath.Join(dir, "pids.max"))
+	}
+	return nil
+}
+
+// readIOStat parses the "major:minor key=value..." lines of io.stat into the
+// bytes and operations read and written per device, the way Docker reports
+// them on cgroup v2
+func readIOStat(path string) (bytes, ios []types.BlkioStatEntry, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	ops := map[string]struct {
+		op      string
+		entries *[]types.BlkioStatEntry
+	}{
+		"rbytes": {"read", &bytes},
+		"wbytes": {"write", &bytes},
+		"rios":   {"read", &ios},
+		"wios":   {"write", &ios},
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		var major, minor uint64
+		if _, err := fmt.Sscanf(fields[0], "%d:%d", &major, &minor); err != nil {
+			return nil, nil, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+		}
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			o, ok := ops[parts[0]]
+			if !ok || len(parts) != 2 {
+				continue
+			}
+			v, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to parse %s: %q: %v", path, line, err)
+			}
+			*o.entries = append(*o.entries, types.BlkioStatEntry{Major: major, Minor: minor, Op: o.op, Value: v})
+		}
+	}
+	return bytes, ios, nil
+}
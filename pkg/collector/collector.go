@@ -0,0 +1,237 @@
+// Package collector turns the stats of an ECS task's containers into
+// CloudWatch metric data.
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cw"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
+)
+
+// Collector collects the metric data of the running containers of a task
+type Collector struct {
+	Source ecs.MetadataSource
+
+	mu      sync.RWMutex
+	config  *config.Config
+	last    *Sample
+	history *history
+}
+
+// New returns a Collector getting stats from source, configured with cfg
+func New(source ecs.MetadataSource, cfg *config.Config) *Collector {
+	return &Collector{Source: source, config: cfg, history: newHistory()}
+}
+
+// Config returns the configuration in use
+func (c *Collector) Config() *config.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// SetConfig replaces the configuration, the next collection uses cfg
+func (c *Collector) SetConfig(cfg *config.Config) {
+	c.mu.Lock()
+	c.config = cfg
+	c.mu.Unlock()
+}
+
+// Data are metric data by CloudWatch namespace
+type Data map[string][]*cloudwatch.MetricDatum
+
+// Sample is the result of one collection, kept for debugging
+type Sample struct {
+	CollectedAt time.Time               `json:"collectedAt"`
+	Stats       map[string]*types.Stats `json:"stats"`
+	// StatsJSON are the whole stats, when the source has them
+	StatsJSON map[string]*types.StatsJSON `json:"statsJSON,omitempty"`
+	Datums    Data                        `json:"datums"`
+}
+
+// Last returns the last successfully collected sample, or nil if none
+func (c *Collector) Last() *Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.last
+}
+
+// Collect gets the current stats of task's containers from the source and
+// returns their metric data. Only the running containers selected by the
+// configured filters are reported, which excludes the ones not started yet
+// while the task is still pending. The labels of a container may override
+// the configuration of its metrics, including their namespace.
+//
+// A sample already collected is skipped, and the CPU utilization isn't
+// reported when it can't be computed, e.g. right after a container restarted.
+// Task-level metrics, such as the ephemeral storage, follow the configuration
+// whatever the labels.
+func (c *Collector) Collect(ctx context.Context, task *ecs.TaskResponse) (Data, error) {
+	cfg := c.Config()
+	taskStats, statsJSON, err := c.taskStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string]ecs.ContainerResponse)
+	for _, con := range ecs.RunningContainers(task) {
+		// We ignore the CNI pause container's metrics
+		if !ecs.IsPauseContainer(con) && selected(cfg.Filters, con) {
+			containers[con.ID] = con
+		}
+	}
+
+	d := make(Data)
+	keys := make(map[string]bool, len(containers))
+	for key, conStats := range taskStats {
+		con, ok := containers[key]
+		// We ignore a not running, a filtered out or a no-stats container
+		if !ok {
+			continue
+		}
+		if conStats == nil {
+			logger.With(logger.Fields{"container": con.Name}).Debugf("no stats for the container yet")
+			continue
+		}
+		key := historyKey(task, con)
+		keys[key] = true
+		conStats, prevStats, skip := c.history.check(key, con.ID, conStats, maxGap(cfg))
+		if skip != "" {
+			telemetry.Default.IncSkippedSamples(skip)
+			log := logger.With(logger.Fields{"container": con.Name, "reason": skip})
+			if skip == skipDuplicate || skip == skipOutOfOrder {
+				log.Debugf("skipping the sample")
+				continue
+			}
+			log.Debugf("skipping the CPU utilization of the sample")
+		}
+		conCfg, errs := cfg.ForContainer(con.Labels)
+		for _, err := range errs {
+			logger.With(logger.Fields{"container": con.Name}).Warnf("%v", err)
+		}
+		dimensions := containerDimensions(conCfg, task, con)
+		var data []*cloudwatch.MetricDatum
+		if conCfg.MetricEnabled(config.MetricMemory) {
+			if docker.DetectFlavor(conStats) == docker.FlavorWindows {
+				data = append(data, cw.GetMemoryPrivateWorkingSet(conStats, dimensions))
+			} else if datum, _ := cw.GetMemoryUtilization(conStats, dimensions); datum != nil {
+				data = append(data, datum)
+			}
+		}
+		if conCfg.MetricEnabled(config.MetricStorage) && prevStats != nil {
+			if read, write := cw.GetStorageThroughput(conStats, prevStats, dimensions); read != nil {
+				data = append(data, read, write)
+			}
+		}
+		if skip == "" {
+			data = append(data, cpuData(conCfg, conStats, dimensions)...)
+		}
+		if conCfg.RevisionMetrics {
+			data = append(data, revisionData(data, task, con)...)
+		}
+		d[conCfg.Namespace] = append(d[conCfg.Namespace], data...)
+	}
+
+	c.history.retain(keys)
+	if cfg.MetricEnabled(config.MetricEphemeralStorage) {
+		d[cfg.Namespace] = append(d[cfg.Namespace], ephemeralStorageData(cfg, task)...)
+	}
+
+	c.mu.Lock()
+	c.last = &Sample{CollectedAt: time.Now(), Stats: taskStats, StatsJSON: statsJSON, Datums: d}
+	c.mu.Unlock()
+	return d, nil
+}
+
+// taskStats returns the stats of the containers, and their whole stats if the
+// source is an ecs.StatsJSONSource
+func (c *Collector) taskStats(ctx context.Context) (map[string]*types.Stats, map[string]*types.StatsJSON, error) {
+	source, ok := c.Source.(ecs.StatsJSONSource)
+	if !ok {
+		stats, err := c.Source.TaskStats(ctx)
+		return stats, nil, err
+	}
+	stats, err := source.TaskStatsJSON(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ecs.StatsOf(stats), stats, nil
+}
+
+// cpuData returns the enabled CPU metric data of stats
+func cpuData(cfg *config.Config, stats *types.Stats, dimensions []*cloudwatch.Dimension) []*cloudwatch.MetricDatum {
+	var data []*cloudwatch.MetricDatum
+	if cfg.MetricEnabled(config.MetricCPU) {
+		if datum, _ := cw.GetCpuUtilization(stats, dimensions); datum != nil {
+			data = append(data, datum)
+		}
+	}
+	user, kernel := cw.GetCpuModeUtilization(stats, dimensions)
+	if cfg.MetricEnabled(config.MetricCPUUser) {
+		data = append(data, user)
+	}
+	if cfg.MetricEnabled(config.MetricCPUKernel) {
+		data = append(data, kernel)
+	}
+	if cfg.MetricEnabled(config.MetricCPUPerCore) {
+		data = append(data, cw.GetCpuCoreUtilization(stats, dimensions, config.DimensionCPU)...)
+	}
+	return data
+}
+
+// minGap is the minimum interval a CPU rate may be computed over, as the
+// metadata endpoint may serve samples 10 seconds apart whatever the interval
+const minGap = 30 * time.Second
+
+// maxGap returns the longest interval a CPU rate may be computed over: a
+// longer one averages out what happened, e.g. after collections failed
+func maxGap(cfg *config.Config) time.Duration {
+	if gap := 3 * cfg.Interval.Duration(); gap > minGap {
+		return gap
+	}
+	return minGap
+}
+
+// historyKey identifies con across restarts, which change its Docker ID
+func historyKey(task *ecs.TaskResponse, con ecs.ContainerResponse) string {
+	taskARN := con.Labels[ecs.LabelTaskARN]
+	if taskARN == "" {
+		taskARN = task.TaskARN
+	}
+	return taskARN + "/" + con.Name
+}
+
+// revisionData returns copies of data with the revision dimensions of con, or
+// nothing if its task definition is unknown
+func revisionData(data []*cloudwatch.MetricDatum, task *ecs.TaskResponse, con ecs.ContainerResponse) []*cloudwatch.MetricDatum {
+	id := ecs.TaskIdentity(task, con)
+	if id.Family == "" || id.Revision == "" {
+		return nil
+	}
+	dimensions := cw.RevisionDimensions(id.Family, id.Revision, con.Name)
+	copies := make([]*cloudwatch.MetricDatum, len(data))
+	for i, datum := range data {
+		c := *datum
+		c.Dimensions = dimensions
+		// Per-core data keep their core apart
+		for _, dim := range datum.Dimensions {
+			if aws.StringValue(dim.Name) == config.DimensionCPU {
+				c.Dimensions = append(append([]*cloudwatch.Dimension{}, dimensions...), dim)
+			}
+		}
+		copies[i] = &c
+	}
+	return copies
+}
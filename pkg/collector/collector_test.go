@@ -0,0 +1,192 @@
+package collector_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/collector"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+const (
+	appContainer     = "ecs-fake-app-1-app"
+	sidecarContainer = "ecs-fake-app-1-taskmetadata-cloudwatch"
+	pauseContainer   = "ecs-fake-app-1-~internal~ecs~pause"
+)
+
+// collect runs samples collection cycles of the default configuration against
+// the fake endpoint serving scenario, and returns the datums of each cycle
+func collect(t *testing.T, scenario fakeecs.Scenario, samples int) [][]*cloudwatch.MetricDatum {
+	server := httptest.NewServer(fakeecs.NewServer(scenario))
+	defer server.Close()
+
+	client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+	client.Version = 4
+	cfg := config.Default()
+	c := collector.New(client, cfg)
+
+	var cycles [][]*cloudwatch.MetricDatum
+	for i := 0; i < samples; i++ {
+		task, err := client.TaskMetadata(context.Background())
+		if err != nil {
+			t.Fatalf("sample %d: unable to get the task metadata: %v", i, err)
+		}
+		d, err := c.Collect(context.Background(), task)
+		if err != nil {
+			t.Fatalf("sample %d: unable to collect: %v", i, err)
+		}
+		cycles = append(cycles, d[cfg.Namespace])
+	}
+	return cycles
+}
+
+// find returns the value of the datum named metric of container, or nil
+func find(datums []*cloudwatch.MetricDatum, metric, container string) *float64 {
+	for _, d := range datums {
+		if aws.StringValue(d.MetricName) != metric {
+			continue
+		}
+		for _, dim := range d.Dimensions {
+			if aws.StringValue(dim.Name) == config.DimensionContainerName && aws.StringValue(dim.Value) == container {
+				return d.Value
+			}
+		}
+	}
+	return nil
+}
+
+func assertValue(t *testing.T, sample int, datums []*cloudwatch.MetricDatum, metric, container string, want float64) {
+	t.Helper()
+	got := find(datums, metric, container)
+	if got == nil {
+		t.Errorf("sample %d: no %s for %s", sample, metric, container)
+		return
+	}
+	if diff := *got - want; diff > 1e-6 || diff < -1e-6 {
+		t.Errorf("sample %d: %s of %s = %v, want %v", sample, metric, container, *got, want)
+	}
+}
+
+func assertMissing(t *testing.T, sample int, datums []*cloudwatch.MetricDatum, metric, container string) {
+	t.Helper()
+	if got := find(datums, metric, container); got != nil {
+		t.Errorf("sample %d: %s of %s = %v, want none", sample, metric, container, *got)
+	}
+}
+
+func TestCollectSteady(t *testing.T) {
+	cycles := collect(t, fakeecs.Steady(), 4)
+	for i, datums := range cycles {
+		assertValue(t, i, datums, "MemoryUtilization", appContainer, 50)
+		assertValue(t, i, datums, "MemoryUtilization", sidecarContainer, 1.5625)
+		// The first sample has no previous CPU usage to compute a rate from
+		if i == 0 {
+			assertMissing(t, i, datums, "CPUUtilization", appContainer)
+			assertMissing(t, i, datums, "CPUUtilization", sidecarContainer)
+			continue
+		}
+		assertValue(t, i, datums, "CPUUtilization", appContainer, 25)
+		assertValue(t, i, datums, "CPUUtilization", sidecarContainer, 1)
+		if len(datums) != 4 {
+			t.Errorf("sample %d: got %d datums, want 4", i, len(datums))
+		}
+	}
+}
+
+func TestCollectRestarting(t *testing.T) {
+	cycles := collect(t, fakeecs.Restarting(), 9)
+	for i, datums := range cycles {
+		assertValue(t, i, datums, "MemoryUtilization", appContainer, 50)
+		// The application restarts with a new ID and reset counters every 6
+		// samples, its CPU utilization can't be computed right after
+		if i%6 == 0 {
+			assertMissing(t, i, datums, "CPUUtilization", appContainer)
+		} else {
+			assertValue(t, i, datums, "CPUUtilization", appContainer, 40)
+		}
+		if i > 0 {
+			assertValue(t, i, datums, "CPUUtilization", sidecarContainer, 1)
+		}
+	}
+}
+
+func TestCollectAWSVPC(t *testing.T) {
+	cycles := collect(t, fakeecs.AWSVPC(), 3)
+	for i, datums := range cycles {
+		for _, d := range datums {
+			for _, dim := range d.Dimensions {
+				if aws.StringValue(dim.Value) == pauseContainer {
+					t.Errorf("sample %d: the CNI pause container is reported: %v", i, d)
+				}
+			}
+		}
+		assertValue(t, i, datums, "MemoryUtilization", appContainer, 50)
+		if i > 0 {
+			assertValue(t, i, datums, "CPUUtilization", appContainer, 25)
+			assertValue(t, i, datums, "CPUUtilization", sidecarContainer, 1)
+		}
+	}
+}
+
+func TestTaskDimensions(t *testing.T) {
+	cfg := config.Default()
+	cfg.Dimensions.Standard = []string{config.DimensionClusterName, config.DimensionContainerName, config.DimensionTaskDefinitionFamily}
+	cfg.Dimensions.Static = map[string]string{"Environment": "test"}
+	cfg.Tags.Task = map[string]string{"Team": "team"}
+
+	var got []string
+	for _, dim := range collector.TaskDimensions(cfg, fakeecs.Steady().Task(0)) {
+		got = append(got, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+	}
+	// The container dimensions but ContainerName
+	want := "ClusterName=fake,TaskDefinitionFamily=fake-app,Environment=test,Team=payments"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got the dimensions %v, want %s", got, want)
+	}
+}
+
+func TestCollectKeepsStatsJSON(t *testing.T) {
+	server := httptest.NewServer(fakeecs.NewServer(fakeecs.Steady()))
+	defer server.Close()
+	client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+	client.Version = 4
+	c := collector.New(client, config.Default())
+
+	task, err := client.TaskMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Collect(context.Background(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := c.Last()
+	var app *ecs.ContainerResponse
+	for i, con := range task.Containers {
+		if con.DockerName == appContainer {
+			app = &task.Containers[i]
+		}
+	}
+	if app == nil {
+		t.Fatalf("no %s in the task", appContainer)
+	}
+	st := last.StatsJSON[app.ID]
+	if st == nil {
+		t.Fatalf("no stats JSON for %s in %v", appContainer, last.StatsJSON)
+	}
+	if st.Name != "/"+appContainer || st.ID != app.ID || st.Networks["eth0"].RxBytes != 1024 {
+		t.Errorf("got the name %q, the ID %q and the networks %+v", st.Name, st.ID, st.Networks)
+	}
+	if last.Stats[app.ID] == nil || last.Stats[app.ID].Read != st.Read {
+		t.Errorf("got the stats %+v, want the ones of the stats JSON", last.Stats[app.ID])
+	}
+}
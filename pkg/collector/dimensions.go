@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cw"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// containerDimensions returns the configured dimensions of con's metrics:
+// the standard ones, valued from the task metadata and the labels the ECS
+// agent puts on containers, the static ones then the ones mapped from tags.
+// A dimension whose value is unknown, such as a tag the task doesn't have, is
+// left out.
+func containerDimensions(cfg *config.Config, task *ecs.TaskResponse, con ecs.ContainerResponse) []*cloudwatch.Dimension {
+	id := ecs.TaskIdentity(task, con)
+	var dimensions []*cloudwatch.Dimension
+	for _, name := range cfg.Dimensions.Standard {
+		var value string
+		switch name {
+		case config.DimensionClusterName:
+			value = task.Cluster
+		case config.DimensionContainerName:
+			value = con.DockerName
+		case config.DimensionServiceName:
+			value = id.ServiceName
+		case config.DimensionTaskDefinitionFamily:
+			value = id.Family
+		case config.DimensionTaskDefinition:
+			value = id.TaskDefinition()
+		case config.DimensionTaskID:
+			value = id.TaskID
+		}
+		// CloudWatch rejects empty dimension values
+		if value != "" {
+			dimensions = append(dimensions, cw.Dimension(name, value))
+		}
+	}
+
+	for _, name := range sortedNames(cfg.Dimensions.Static) {
+		dimensions = append(dimensions, cw.Dimension(name, cfg.Dimensions.Static[name]))
+	}
+	dimensions = append(dimensions, tagDimensions(cfg.Tags.Task, task.TaskTags)...)
+	dimensions = append(dimensions, tagDimensions(cfg.Tags.ContainerInstance, task.ContainerInstanceTags)...)
+	return dimensions
+}
+
+// TaskDimensions returns the configured dimensions of task-level metrics,
+// i.e. the container ones without ContainerName
+func TaskDimensions(cfg *config.Config, task *ecs.TaskResponse) []*cloudwatch.Dimension {
+	// The labels of any container fill in the identity of the task, while
+	// the empty DockerName leaves ContainerName out
+	var con ecs.ContainerResponse
+	if len(task.Containers) > 0 {
+		con.Labels = task.Containers[0].Labels
+	}
+	return containerDimensions(cfg, task, con)
+}
+
+// tagDimensions returns the dimensions mapping, by name, to the keys of tags
+func tagDimensions(mapping map[string]string, tags map[string]string) []*cloudwatch.Dimension {
+	var dimensions []*cloudwatch.Dimension
+	for _, name := range sortedNames(mapping) {
+		if value := tags[mapping[name]]; value != "" {
+			dimensions = append(dimensions, cw.Dimension(name, value))
+		}
+	}
+	return dimensions
+}
+
+// TagProperties returns the EMF properties of task configured by
+// tags.properties, leaving out the tags the task doesn't have
+func TagProperties(cfg *config.Config, task *ecs.TaskResponse) map[string]string {
+	properties := make(map[string]string)
+	addTagProperties(properties, cfg.Tags.Properties.Task, task.TaskTags)
+	addTagProperties(properties, cfg.Tags.Properties.ContainerInstance, task.ContainerInstanceTags)
+	return properties
+}
+
+// addTagProperties adds the properties mapping, by name, to the keys of tags
+func addTagProperties(properties map[string]string, mapping map[string]string, tags map[string]string) {
+	for name, key := range mapping {
+		if value := tags[key]; value != "" {
+			properties[name] = value
+		}
+	}
+}
+
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
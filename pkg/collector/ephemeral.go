@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cw"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+const mib = 1024 * 1024
+
+// ephemeralStorageRoot is the filesystem whose usage is the one of the task's
+// ephemeral storage, which all the containers of a Fargate task share
+const ephemeralStorageRoot = "/"
+
+// ephemeralStorage returns the bytes of ephemeral storage the Fargate task
+// uses and has, from its metadata or else from the filesystem the sidecar
+// runs on. ok is false if the task doesn't run on Fargate or the usage is
+// unknown.
+func ephemeralStorage(task *ecs.TaskResponse) (used, reserved uint64, ok bool) {
+	if m := task.EphemeralStorageMetrics; m != nil && m.Reserved > 0 {
+		return uint64(m.Utilized) * mib, uint64(m.Reserved) * mib, true
+	}
+	// The filesystem is the task's own on Fargate only, it is the host's
+	// otherwise
+	if task.LaunchType != ecs.LaunchTypeFargate {
+		return 0, 0, false
+	}
+	used, reserved, err := filesystemUsage(ephemeralStorageRoot)
+	if err != nil {
+		logger.Debugf("unable to get the ephemeral storage usage: %v", err)
+		return 0, 0, false
+	}
+	return used, reserved, reserved > 0
+}
+
+// ephemeralStorageData returns the task-level ephemeral storage metric data
+// of task, or nothing if its usage is unknown
+func ephemeralStorageData(cfg *config.Config, task *ecs.TaskResponse) []*cloudwatch.MetricDatum {
+	used, reserved, ok := ephemeralStorage(task)
+	if !ok {
+		return nil
+	}
+	utilized, utilization := cw.GetEphemeralStorage(used, reserved, TaskDimensions(cfg, task))
+	return []*cloudwatch.MetricDatum{utilized, utilization}
+}
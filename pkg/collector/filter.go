@@ -0,0 +1,23 @@
+package collector
+
+import (
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// selected returns true if con passes the filters
+func selected(filters config.Filters, con ecs.ContainerResponse) bool {
+	if len(filters.Include) > 0 && !matchesAny(filters.Include, con) {
+		return false
+	}
+	return !matchesAny(filters.Exclude, con)
+}
+
+func matchesAny(rules []config.Rule, con ecs.ContainerResponse) bool {
+	for _, r := range rules {
+		if r.Matches(con.Name, con.Image, con.Labels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
+)
+
+// Reasons why the stats of a container aren't turned into metrics
+const (
+	// skipRestarted is a container whose ID changed, its counters started
+	// over and the previous sample isn't comparable
+	skipRestarted = "restarted"
+	// skipCounterReset is a cumulative CPU counter which went backwards
+	skipCounterReset = "counter_reset"
+	// skipDuplicate is the same sample as the previous collection, already
+	// published
+	skipDuplicate = "duplicate"
+	// skipOutOfOrder is a sample older than the previous one
+	skipOutOfOrder = "out_of_order"
+	// skipGap is a rate computed over a longer interval than maxGap
+	skipGap = "gap"
+	// skipNoPrevious is the first sample of a container, without previous
+	// CPU stats to compute a rate from
+	skipNoPrevious = "no_previous"
+)
+
+// history keeps the last sample of every container, by ECS container, to tell
+// the samples the CPU rate can't be computed from
+type history struct {
+	mu      sync.Mutex
+	samples map[string]*previous
+}
+
+type previous struct {
+	id    string
+	stats *types.Stats
+}
+
+func newHistory() *history {
+	return &history{samples: make(map[string]*previous)}
+}
+
+// check returns the stats of the ECS container key to compute the metrics
+// from, whose Docker ID is id, the previous sample of the same container if
+// kept, and why the CPU rate can't be computed from them, if it can't. A
+// duplicate or out of order sample must not be used at all.
+//
+// Docker computes the rate between two of its own readings, but the previous
+// one is missing from the first sample of a stream. It is then taken from the
+// last sample kept, if recent enough.
+func (h *history) check(key, id string, stats *types.Stats, maxGap time.Duration) (*types.Stats, *types.Stats, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.samples[key]
+	restarted := prev != nil && prev.id != id
+
+	if prev != nil && !restarted {
+		switch {
+		case stats.Read.Equal(prev.stats.Read):
+			return stats, nil, skipDuplicate
+		case stats.Read.Before(prev.stats.Read):
+			return stats, nil, skipOutOfOrder
+		}
+	}
+	h.samples[key] = &previous{id: id, stats: stats}
+	if restarted {
+		prev = nil
+	}
+	var prevStats *types.Stats
+	if prev != nil {
+		prevStats = prev.stats
+	}
+
+	if !hasPreviousCPU(stats) {
+		switch {
+		case restarted:
+			return stats, nil, skipRestarted
+		case prev == nil || !hasCPU(prev.stats):
+			return stats, prevStats, skipNoPrevious
+		}
+		s := *stats
+		s.PreRead, s.PreCPUStats = prev.stats.Read, prev.stats.CPUStats
+		stats = &s
+	}
+
+	switch {
+	case stats.CPUStats.CPUUsage.TotalUsage < stats.PreCPUStats.CPUUsage.TotalUsage,
+		stats.CPUStats.SystemUsage < stats.PreCPUStats.SystemUsage,
+		prev != nil && stats.CPUStats.CPUUsage.TotalUsage < prev.stats.CPUStats.CPUUsage.TotalUsage:
+		return stats, prevStats, skipCounterReset
+	case !stats.PreRead.IsZero() && stats.Read.Sub(stats.PreRead) > maxGap:
+		return stats, prevStats, skipGap
+	}
+	return stats, prevStats, ""
+}
+
+// hasCPU returns true if stats has CPU counters to compute a rate from. There
+// is no system CPU usage on Windows, where the time between readings stands
+// for it.
+func hasCPU(stats *types.Stats) bool {
+	return docker.DetectFlavor(stats) == docker.FlavorWindows || stats.CPUStats.SystemUsage != 0
+}
+
+// hasPreviousCPU returns true if stats has the CPU counters of the previous
+// reading
+func hasPreviousCPU(stats *types.Stats) bool {
+	if docker.DetectFlavor(stats) == docker.FlavorWindows {
+		return !stats.PreRead.IsZero()
+	}
+	return stats.PreCPUStats.SystemUsage != 0
+}
+
+// retain forgets the containers not in keys, which stopped
+func (h *history) retain(keys map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.samples {
+		if !keys[key] {
+			delete(h.samples, key)
+		}
+	}
+}
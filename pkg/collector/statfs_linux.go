@@ -0,0 +1,16 @@
+package collector
+
+import (
+	"syscall"
+)
+
+// filesystemUsage returns the bytes used and the size of the filesystem path
+// is on
+func filesystemUsage(path string) (used, size uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	return (st.Blocks - st.Bfree) * bsize, st.Blocks * bsize, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// filesystemUsage isn't supported outside of Linux
+func filesystemUsage(path string) (used, size uint64, err error) {
+	return 0, 0, fmt.Errorf("filesystem stats aren't supported on %s", runtime.GOOS)
+}
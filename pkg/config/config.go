@@ -0,0 +1,565 @@
+// Package config defines the sidecar's declarative configuration, how it is
+// loaded and how it is validated.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cgroup"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+// Short names of the metrics which can be enabled
+const (
+	MetricCPU    = "cpu"
+	MetricMemory = "memory"
+	// MetricCPUUser and MetricCPUKernel split the CPU utilization between
+	// user and kernel mode
+	MetricCPUUser   = "cpuUser"
+	MetricCPUKernel = "cpuKernel"
+	// MetricCPUPerCore is the utilization of every CPU core, with the
+	// DimensionCPU dimension
+	MetricCPUPerCore = "cpuPerCore"
+	// MetricStorage is the storage read and write throughput
+	MetricStorage = "storage"
+	// MetricEphemeralStorage is the ephemeral storage used by a Fargate task,
+	// reported at the task level
+	MetricEphemeralStorage = "ephemeralStorage"
+)
+
+// Names of the standard dimensions, whose values come from the task metadata
+const (
+	DimensionClusterName          = "ClusterName"
+	DimensionContainerName        = "ContainerName"
+	DimensionServiceName          = "ServiceName"
+	DimensionTaskDefinitionFamily = "TaskDefinitionFamily"
+	// DimensionTaskDefinition is valued "family:revision"
+	DimensionTaskDefinition = "TaskDefinition"
+	DimensionTaskID         = "TaskId"
+	// DimensionCPU is the index of the CPU core of MetricCPUPerCore, only
+	// put on that metric
+	DimensionCPU = "CPU"
+	// DimensionTaskDefinitionRevision and DimensionContainerDefinitionName
+	// are only put on the revision metrics
+	DimensionTaskDefinitionRevision  = "TaskDefinitionRevision"
+	DimensionContainerDefinitionName = "ContainerDefinitionName"
+)
+
+// Types of sinks
+const (
+	SinkCloudWatch = "cloudwatch"
+	// SinkEMF writes the metrics to standard output in the Embedded Metric
+	// Format, for the awslogs log driver to turn them into metrics
+	SinkEMF = "emf"
+)
+
+// Modes of operation
+const (
+	// ModeTask reports the task the sidecar runs in
+	ModeTask = "task"
+	// ModeDaemon reports every task of the EC2 container instance
+	ModeDaemon = "daemon"
+)
+
+// Sources of the task metadata and stats in ModeTask
+const (
+	// SourceMetadata reads them from the task metadata endpoint
+	SourceMetadata = "metadata"
+	// SourceDocker streams the stats of the task's containers from Docker
+	SourceDocker = "docker"
+	// SourceCgroup reads the stats of the task's containers from the cgroup
+	// filesystem
+	SourceCgroup = "cgroup"
+)
+
+// KnownMetrics are the metrics which can be enabled
+var KnownMetrics = []string{MetricCPU, MetricMemory, MetricCPUUser, MetricCPUKernel, MetricCPUPerCore, MetricStorage, MetricEphemeralStorage}
+
+// KnownDimensions are the standard dimensions
+var KnownDimensions = []string{
+	DimensionClusterName,
+	DimensionContainerName,
+	DimensionServiceName,
+	DimensionTaskDefinitionFamily,
+	DimensionTaskDefinition,
+	DimensionTaskID,
+}
+
+// KnownSinks are the types of sinks
+var KnownSinks = []string{SinkCloudWatch, SinkEMF}
+
+// MetricNames are the names of the metrics put to CloudWatch, which the EMF
+// properties mustn't take
+var MetricNames = []string{
+	"CPUUtilization", "CPUUserUtilization", "CPUKernelUtilization", "CPUCoreUtilization",
+	"MemoryUtilization", "MemoryPrivateWorkingSet", "StorageReadBytes", "StorageWriteBytes",
+	"EphemeralStorageUtilized", "EphemeralStorageUtilization", "TaskStopping",
+}
+
+// KnownModes are the modes of operation
+var KnownModes = []string{ModeTask, ModeDaemon}
+
+// KnownSources are the sources of ModeTask
+var KnownSources = []string{SourceMetadata, SourceDocker, SourceCgroup}
+
+const (
+	// maxDimensions is the maximum number of dimensions CloudWatch accepts on
+	// a metric
+	maxDimensions = 10
+	// maxNameLength is the maximum length of a CloudWatch namespace, dimension
+	// name or dimension value
+	maxNameLength = 255
+)
+
+// Config is the sidecar's configuration
+type Config struct {
+	// Mode is ModeTask or ModeDaemon
+	Mode string `json:"mode"`
+	// Daemon configures ModeDaemon
+	Daemon Daemon `json:"daemon"`
+	// Source configures where ModeTask gets the stats from
+	Source Source `json:"source"`
+	// Interval is how often the stats are collected and published
+	Interval Duration `json:"interval"`
+	// Namespace is the CloudWatch namespace of the containers' metrics. The
+	// sidecar's own metrics go to Namespace + "/Publisher".
+	Namespace string `json:"namespace"`
+	// Metrics are the short names of the enabled metrics, see KnownMetrics
+	Metrics []string `json:"metrics"`
+	// Dimensions are the dimensions put on every container metric
+	Dimensions Dimensions `json:"dimensions"`
+	// Tags maps ECS tags to dimensions
+	Tags Tags `json:"tags"`
+	// RevisionMetrics also publishes every metric with the task definition
+	// family, revision and container name only, to compare revisions
+	RevisionMetrics bool `json:"revisionMetrics"`
+	// Filters select the containers to publish metrics for
+	Filters Filters `json:"filters"`
+	// Sinks are where the metrics are sent
+	Sinks []Sink `json:"sinks"`
+	// DryRun writes the requests the CloudWatch sinks would send instead
+	DryRun DryRun `json:"dryRun"`
+	// ContainerLabels lets the Docker labels of a container override the
+	// configuration of its metrics, see LabelPrefix
+	ContainerLabels bool `json:"containerLabels"`
+	// SelfMetrics also puts the sidecar's own metrics to CloudWatch
+	SelfMetrics bool `json:"selfMetrics"`
+
+	// StartupTimeout is the maximum time to wait for the task to become
+	// RUNNING, 0 waits forever
+	StartupTimeout Duration `json:"startupTimeout"`
+	// ShutdownTimeout is the maximum time to flush the final metrics
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// Listen is the address of the HTTP endpoints, disabled if empty
+	Listen string `json:"listen"`
+	// ReadyMaxPublishAge is how long /readyz tolerates no successful publish,
+	// 0 disables the check
+	ReadyMaxPublishAge Duration `json:"readyMaxPublishAge"`
+
+	Log Log `json:"log"`
+}
+
+// Dimensions configures the dimensions of the container metrics
+type Dimensions struct {
+	// Standard are the names of the standard dimensions to put, see
+	// KnownDimensions
+	Standard []string `json:"standard"`
+	// Static are additional dimensions with a fixed value, e.g. Environment
+	Static map[string]string `json:"static,omitempty"`
+}
+
+// Daemon configures where ModeDaemon gets the tasks and stats from
+type Daemon struct {
+	// AgentURL is the base URL of the ECS agent introspection API
+	AgentURL string `json:"agentURL"`
+	// DockerSocket is the path of the Docker Engine API socket
+	DockerSocket string `json:"dockerSocket"`
+}
+
+// Source configures where ModeTask gets the task's containers and their stats
+// from
+type Source struct {
+	// Type is SourceMetadata, SourceDocker or SourceCgroup
+	Type string `json:"type"`
+	// DockerSocket is the path of the Docker Engine API socket SourceDocker
+	// reads from
+	DockerSocket string `json:"dockerSocket"`
+	// CgroupRoot and ProcRoot are where SourceCgroup finds the cgroup and
+	// proc filesystems of the host
+	CgroupRoot string `json:"cgroupRoot"`
+	ProcRoot   string `json:"procRoot"`
+}
+
+// Tags maps the tags of the task and of the container instance it runs on to
+// dimensions, by dimension name. Only the tag keys listed here are used, so
+// that adding tags can't multiply the number of metrics.
+type Tags struct {
+	// Task maps dimension names to task tag keys, e.g. {"Team": "team"}
+	Task map[string]string `json:"task,omitempty"`
+	// ContainerInstance maps dimension names to container instance tag keys,
+	// EC2 launch type only
+	ContainerInstance map[string]string `json:"containerInstance,omitempty"`
+	// Properties maps tags to properties of the EMF documents instead,
+	// which don't add metrics
+	Properties TagProperties `json:"properties"`
+}
+
+// TagProperties maps the tags of the task and of the container instance to
+// properties of the Embedded Metric Format documents, by property name
+type TagProperties struct {
+	Task              map[string]string `json:"task,omitempty"`
+	ContainerInstance map[string]string `json:"containerInstance,omitempty"`
+}
+
+// Enabled returns true if any tag is mapped
+func (t Tags) Enabled() bool {
+	return len(t.Task) > 0 || len(t.ContainerInstance) > 0 ||
+		len(t.Properties.Task) > 0 || len(t.Properties.ContainerInstance) > 0
+}
+
+// Sink configures one destination of the metrics
+type Sink struct {
+	// Type is the type of the sink, see KnownSinks
+	Type string `json:"type"`
+	// MaxBuffered is the number of datums kept while the destination is
+	// unavailable
+	MaxBuffered int `json:"maxBuffered,omitempty"`
+}
+
+// DryRun configures the dry run, which writes every PutMetricData request
+// as a JSON line rather than sending it
+type DryRun struct {
+	Enabled bool `json:"enabled"`
+	// Path is the file the requests are appended to, standard output if
+	// empty
+	Path string `json:"path,omitempty"`
+}
+
+// Log configures the logs
+type Log struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+const defaultMaxBuffered = 1000
+
+// Default returns the configuration used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Mode: ModeTask,
+		Daemon: Daemon{
+			AgentURL:     ecs.DefaultAgentURL,
+			DockerSocket: docker.DefaultSocket,
+		},
+		Source: Source{
+			Type:         SourceMetadata,
+			DockerSocket: docker.DefaultSocket,
+			CgroupRoot:   cgroup.DefaultRoot,
+			ProcRoot:     cgroup.DefaultProcRoot,
+		},
+		Interval:  Duration(10 * time.Second),
+		Namespace: "ECS/Containers",
+		Metrics:   []string{MetricCPU, MetricMemory},
+		Dimensions: Dimensions{
+			Standard: []string{DimensionClusterName, DimensionContainerName},
+		},
+		Sinks:              []Sink{{Type: SinkCloudWatch, MaxBuffered: defaultMaxBuffered}},
+		ContainerLabels:    true,
+		StartupTimeout:     Duration(10 * time.Minute),
+		ShutdownTimeout:    Duration(25 * time.Second),
+		ReadyMaxPublishAge: Duration(5 * time.Minute),
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// PublisherNamespace returns the namespace of the sidecar's own metrics
+func (c *Config) PublisherNamespace() string {
+	return c.Namespace + "/Publisher"
+}
+
+// MetricEnabled returns true if the metric named name is enabled
+func (c *Config) MetricEnabled(name string) bool {
+	return contains(c.Metrics, name)
+}
+
+// reservedDimension returns true if name is a dimension put on some of the
+// enabled metrics only
+func (c *Config) reservedDimension(name string) bool {
+	return name == DimensionCPU && c.MetricEnabled(MetricCPUPerCore)
+}
+
+func (c *Config) applyDefaults() {
+	for i := range c.Sinks {
+		if c.Sinks[i].MaxBuffered == 0 {
+			c.Sinks[i].MaxBuffered = defaultMaxBuffered
+		}
+	}
+}
+
+// FieldError is a validation error of one field of the configuration
+type FieldError struct {
+	// Path is the JSON path of the field, e.g. "sinks[0].type"
+	Path string
+	// Line is the line of the field in the configuration, 0 if unknown
+	Line    int
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	// Source names where the configuration came from, e.g. its file path
+	Source string
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return fmt.Sprintf("invalid configuration %s:\n  %s", e.Source, strings.Join(msgs, "\n  "))
+}
+
+// Validate returns every problem of c, without line numbers
+func (c *Config) Validate() []FieldError {
+	var errs []FieldError
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !contains(KnownModes, c.Mode) {
+		add("mode", "unknown mode %q, must be one of %s", c.Mode, strings.Join(KnownModes, ", "))
+	}
+	if c.Mode == ModeDaemon {
+		if c.Daemon.AgentURL == "" {
+			add("daemon.agentURL", "must not be empty")
+		}
+		if c.Daemon.DockerSocket == "" {
+			add("daemon.dockerSocket", "must not be empty")
+		}
+	}
+	switch {
+	case !contains(KnownSources, c.Source.Type):
+		add("source.type", "unknown source %q, must be one of %s", c.Source.Type, strings.Join(KnownSources, ", "))
+	case c.Source.Type != SourceMetadata && c.Mode == ModeDaemon:
+		add("source.type", "must be %s in %s mode, which always reads the stats from Docker", SourceMetadata, ModeDaemon)
+	case c.Source.Type == SourceDocker && c.Source.DockerSocket == "":
+		add("source.dockerSocket", "must not be empty")
+	case c.Source.Type == SourceCgroup:
+		if c.Source.CgroupRoot == "" {
+			add("source.cgroupRoot", "must not be empty")
+		}
+		if c.Source.ProcRoot == "" {
+			add("source.procRoot", "must not be empty")
+		}
+	}
+
+	if c.Interval.Duration() < time.Second {
+		add("interval", "must be at least 1s, got %v", c.Interval)
+	}
+	switch {
+	case c.Namespace == "":
+		add("namespace", "must not be empty")
+	case strings.HasPrefix(c.Namespace, "AWS/"):
+		add("namespace", "must not start with AWS/, it is reserved for AWS services")
+	case len(c.PublisherNamespace()) > maxNameLength:
+		add("namespace", "must be at most %d characters long", maxNameLength-len("/Publisher"))
+	}
+
+	if len(c.Metrics) == 0 {
+		add("metrics", "must enable at least one metric of %s", strings.Join(KnownMetrics, ", "))
+	}
+	for i, m := range c.Metrics {
+		if !contains(KnownMetrics, m) {
+			add(fmt.Sprintf("metrics[%d]", i), "unknown metric %q, must be one of %s", m, strings.Join(KnownMetrics, ", "))
+		}
+	}
+
+	for i, d := range c.Dimensions.Standard {
+		if !contains(KnownDimensions, d) {
+			add(fmt.Sprintf("dimensions.standard[%d]", i), "unknown dimension %q, must be one of %s", d, strings.Join(KnownDimensions, ", "))
+		}
+	}
+	for _, name := range sortedKeys(c.Dimensions.Static) {
+		value := c.Dimensions.Static[name]
+		path := "dimensions.static." + name
+		if name == "" || len(name) > maxNameLength {
+			add(path, "name must be 1 to %d characters long", maxNameLength)
+		}
+		if contains(KnownDimensions, name) || c.reservedDimension(name) {
+			add(path, "conflicts with the standard dimension of the same name")
+		}
+		if value == "" || len(value) > maxNameLength {
+			add(path, "value must be 1 to %d characters long", maxNameLength)
+		}
+	}
+	tagDimensions := func(path string, tags map[string]string, other map[string]string) {
+		for _, name := range sortedKeys(tags) {
+			p := path + "." + name
+			if name == "" || len(name) > maxNameLength {
+				add(p, "dimension name must be 1 to %d characters long", maxNameLength)
+			}
+			if contains(KnownDimensions, name) || c.reservedDimension(name) {
+				add(p, "conflicts with the standard dimension of the same name")
+			}
+			if _, ok := c.Dimensions.Static[name]; ok {
+				add(p, "conflicts with the static dimension of the same name")
+			}
+			if _, ok := other[name]; ok {
+				add(p, "maps the same dimension as another tag")
+			}
+			if tags[name] == "" {
+				add(p, "tag key must not be empty")
+			}
+		}
+	}
+	tagDimensions("tags.task", c.Tags.Task, nil)
+	tagDimensions("tags.containerInstance", c.Tags.ContainerInstance, c.Tags.Task)
+	// Properties share the EMF documents with the dimensions
+	tagProperties := func(path string, tags map[string]string, other map[string]string) {
+		for _, name := range sortedKeys(tags) {
+			p := path + "." + name
+			if name == "" || name == "_aws" {
+				add(p, "property name must not be empty or _aws")
+			}
+			_, task := c.Tags.Task[name]
+			_, instance := c.Tags.ContainerInstance[name]
+			_, static := c.Dimensions.Static[name]
+			revision := c.RevisionMetrics && (name == DimensionTaskDefinitionRevision || name == DimensionContainerDefinitionName)
+			if contains(KnownDimensions, name) || c.reservedDimension(name) || task || instance || static || revision {
+				add(p, "conflicts with the dimension of the same name")
+			}
+			if contains(MetricNames, name) {
+				add(p, "conflicts with the metric of the same name")
+			}
+			if _, ok := other[name]; ok {
+				add(p, "maps the same property as another tag")
+			}
+			if tags[name] == "" {
+				add(p, "tag key must not be empty")
+			}
+		}
+	}
+	tagProperties("tags.properties.task", c.Tags.Properties.Task, nil)
+	tagProperties("tags.properties.containerInstance", c.Tags.Properties.ContainerInstance, c.Tags.Properties.Task)
+
+	n := len(c.Dimensions.Standard) + len(c.Dimensions.Static) + len(c.Tags.Task) + len(c.Tags.ContainerInstance)
+	if c.MetricEnabled(MetricCPUPerCore) {
+		n++
+	}
+	if n > maxDimensions {
+		add("dimensions", "CloudWatch accepts at most %d dimensions per metric, got %d", maxDimensions, n)
+	}
+
+	for i, r := range c.Filters.Include {
+		errs = append(errs, r.validate(fmt.Sprintf("filters.include[%d]", i))...)
+	}
+	for i, r := range c.Filters.Exclude {
+		errs = append(errs, r.validate(fmt.Sprintf("filters.exclude[%d]", i))...)
+	}
+
+	if len(c.Sinks) == 0 {
+		add("sinks", "must configure at least one sink")
+	}
+	for i, s := range c.Sinks {
+		if !contains(KnownSinks, s.Type) {
+			add(fmt.Sprintf("sinks[%d].type", i), "unknown sink type %q, must be one of %s", s.Type, strings.Join(KnownSinks, ", "))
+		}
+		if s.MaxBuffered < 0 {
+			add(fmt.Sprintf("sinks[%d].maxBuffered", i), "must not be negative")
+		}
+	}
+
+	if c.StartupTimeout < 0 {
+		add("startupTimeout", "must not be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		add("shutdownTimeout", "must be positive")
+	}
+	if c.ReadyMaxPublishAge < 0 {
+		add("readyMaxPublishAge", "must not be negative")
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		add("log.level", "%v", err)
+	}
+	if _, err := logger.ParseFormat(c.Log.Format); err != nil {
+		add("log.format", "%v", err)
+	}
+	return errs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Duration is a time.Duration written as a string such as "10s" in JSON
+type Duration time.Duration
+
+// Duration returns d as a time.Duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	// Like encoding/json, null leaves the value as is
+	if string(b) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return &durationError{raw: b, msg: "must be a string such as \"10s\""}
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return &durationError{raw: b, msg: err.Error()}
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// durationError keeps the invalid JSON text of a Duration, so that its line
+// can be found although encoding/json doesn't report where it failed
+type durationError struct {
+	raw []byte
+	msg string
+}
+
+func (e *durationError) Error() string {
+	// Anything but a string may span lines
+	if !bytes.HasPrefix(e.raw, []byte(`"`)) {
+		return "invalid duration: " + e.msg
+	}
+	return fmt.Sprintf("invalid duration %s: %s", e.raw, e.msg)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validate returns the "path: message" of the validation errors of cfg
+func validate(cfg *Config) []string {
+	var errs []string
+	for _, e := range cfg.Validate() {
+		errs = append(errs, e.Path+": "+e.Message)
+	}
+	return errs
+}
+
+func TestValidateTagProperties(t *testing.T) {
+	cases := []struct {
+		name       string
+		properties TagProperties
+		revision   bool
+		// want is the start of the only expected error, none if empty
+		want string
+	}{
+		{name: "valid", properties: TagProperties{Task: map[string]string{"Owner": "owner"}}},
+		{name: "metric", properties: TagProperties{Task: map[string]string{"CPUUtilization": "cpu"}},
+			want: "tags.properties.task.CPUUtilization: conflicts with the metric"},
+		{name: "standard dimension", properties: TagProperties{Task: map[string]string{"ClusterName": "cluster"}},
+			want: "tags.properties.task.ClusterName: conflicts with the dimension"},
+		{name: "tag dimension", properties: TagProperties{ContainerInstance: map[string]string{"Team": "team"}},
+			want: "tags.properties.containerInstance.Team: conflicts with the dimension"},
+		{name: "revision dimension", properties: TagProperties{Task: map[string]string{"TaskDefinitionRevision": "rev"}}, revision: true,
+			want: "tags.properties.task.TaskDefinitionRevision: conflicts with the dimension"},
+		{name: "revision dimension without revision metrics", properties: TagProperties{Task: map[string]string{"TaskDefinitionRevision": "rev"}}},
+		{name: "both tags", properties: TagProperties{Task: map[string]string{"Owner": "owner"}, ContainerInstance: map[string]string{"Owner": "owner"}},
+			want: "tags.properties.containerInstance.Owner: maps the same property"},
+		{name: "reserved", properties: TagProperties{Task: map[string]string{"_aws": "aws"}},
+			want: "tags.properties.task._aws: property name must not be empty or _aws"},
+		{name: "empty tag key", properties: TagProperties{Task: map[string]string{"Owner": ""}},
+			want: "tags.properties.task.Owner: tag key must not be empty"},
+	}
+	for _, c := range cases {
+		cfg := Default()
+		cfg.Tags.Task = map[string]string{"Team": "team"}
+		cfg.Tags.Properties = c.properties
+		cfg.RevisionMetrics = c.revision
+		errs := validate(cfg)
+		switch {
+		case c.want == "" && len(errs) > 0:
+			t.Errorf("%s: got the errors %v", c.name, errs)
+		case c.want != "" && (len(errs) != 1 || !strings.HasPrefix(errs[0], c.want)):
+			t.Errorf("%s: got the errors %v, want %s", c.name, errs, c.want)
+		}
+	}
+}
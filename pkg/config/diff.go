@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Diff returns the changes from old to new, one per JSON path whose value
+// differs, e.g. `interval: "10s" -> "30s"`, sorted by path
+func Diff(old, new *Config) []string {
+	before, after := flatten(old), flatten(new)
+	paths := make(map[string]bool)
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+
+	var changes []string
+	for p := range paths {
+		b, inBefore := before[p]
+		a, inAfter := after[p]
+		switch {
+		case !inBefore:
+			changes = append(changes, fmt.Sprintf("%s: added %s", p, a))
+		case !inAfter:
+			changes = append(changes, fmt.Sprintf("%s: removed %s", p, b))
+		case a != b:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", p, b, a))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// flatten returns the JSON text of every scalar of c by path
+func flatten(c *Config) map[string]string {
+	var v interface{}
+	b, _ := json.Marshal(c)
+	json.Unmarshal(b, &v)
+
+	values := make(map[string]string)
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, child := range t {
+				if path != "" {
+					k = path + "." + k
+				}
+				walk(k, child)
+			}
+		case []interface{}:
+			for i, child := range t {
+				walk(path+"["+strconv.Itoa(i)+"]", child)
+			}
+		default:
+			b, _ := json.Marshal(t)
+			values[path] = string(b)
+		}
+	}
+	walk("", v)
+	return values
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(*Config)
+		want   []string
+	}{
+		{name: "unchanged", change: func(*Config) {}},
+		{
+			name: "scalars",
+			change: func(c *Config) {
+				c.Interval = Duration(30 * time.Second)
+				c.Namespace = "Payments"
+			},
+			want: []string{
+				`interval: "10s" -> "30s"`,
+				`namespace: "ECS/Containers" -> "Payments"`,
+			},
+		},
+		{
+			name:   "added",
+			change: func(c *Config) { c.Dimensions.Static = map[string]string{"Team": "payments"} },
+			want:   []string{`dimensions.static.Team: added "payments"`},
+		},
+		{
+			name:   "list",
+			change: func(c *Config) { c.Metrics = []string{"cpu"} },
+			want:   []string{`metrics[1]: removed "memory"`},
+		},
+		{
+			name:   "list element",
+			change: func(c *Config) { c.Sinks = []Sink{{Type: SinkEMF}} },
+			want: []string{
+				`sinks[0].maxBuffered: removed 1000`,
+				`sinks[0].type: "cloudwatch" -> "emf"`,
+			},
+		},
+	}
+	for _, c := range cases {
+		old, new := Default(), Default()
+		c.change(new)
+		if got := Diff(old, new); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
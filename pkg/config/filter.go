@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filters select containers. A container is published if it matches a rule
+// of Include, or Include is empty, and it matches no rule of Exclude.
+type Filters struct {
+	Include []Rule `json:"include,omitempty"`
+	Exclude []Rule `json:"exclude,omitempty"`
+}
+
+// Rule matches the containers matching every pattern it sets. A pattern is a
+// glob such as "envoy*", or a regular expression between slashes such as
+// "/^(envoy|xray)$/".
+type Rule struct {
+	// Name matches the name of the container in the task definition
+	Name string `json:"name,omitempty"`
+	// Image matches the image of the container, e.g. "*/aws-xray-daemon:*"
+	Image string `json:"image,omitempty"`
+	// Label is "key=pattern" to match the value of a Docker label, or "key"
+	// to match any container having the label
+	Label string `json:"label,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. A string is a rule on the name
+// only.
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*r = Rule{Name: name}
+		return nil
+	}
+	type rule Rule
+	return json.Unmarshal(b, (*rule)(r))
+}
+
+// Matches returns true if a container named name, running image and labeled
+// with labels matches r. Invalid patterns, which Validate reports, match
+// nothing.
+func (r Rule) Matches(name, image string, labels map[string]string) bool {
+	if r.Name != "" && !MatchPattern(r.Name, name) {
+		return false
+	}
+	if r.Image != "" && !MatchPattern(r.Image, image) {
+		return false
+	}
+	if r.Label != "" {
+		key, pattern, hasValue := r.labelPattern()
+		value, ok := labels[key]
+		if !ok || hasValue && !MatchPattern(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Rule) labelPattern() (key, pattern string, hasValue bool) {
+	if i := strings.Index(r.Label, "="); i >= 0 {
+		return r.Label[:i], r.Label[i+1:], true
+	}
+	return r.Label, "", false
+}
+
+// validate returns the problems of r, path being its JSON path
+func (r Rule) validate(path string) []FieldError {
+	var errs []FieldError
+	if r.Name == "" && r.Image == "" && r.Label == "" {
+		errs = append(errs, FieldError{Path: path, Message: "must set at least one of name, image and label"})
+	}
+	check := func(field, pattern string) {
+		if _, err := compilePattern(pattern); err != nil {
+			errs = append(errs, FieldError{Path: path + "." + field, Message: err.Error()})
+		}
+	}
+	check("name", r.Name)
+	check("image", r.Image)
+	if r.Label != "" {
+		key, pattern, _ := r.labelPattern()
+		if key == "" {
+			errs = append(errs, FieldError{Path: path + ".label", Message: "must start with the label key"})
+		}
+		check("label", pattern)
+	}
+	return errs
+}
+
+// MatchPattern returns true if s matches pattern, a glob or a regular
+// expression between slashes. An invalid pattern matches nothing.
+func MatchPattern(pattern, s string) bool {
+	re, err := compilePattern(pattern)
+	return err == nil && re.MatchString(s)
+}
+
+// compilePattern returns pattern as a regular expression. In a glob, "*"
+// matches any sequence, "/" included so that "*fluent-bit*" matches an image
+// with a registry, and "?" matches any one character.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return re, nil
+	}
+	glob := regexp.QuoteMeta(pattern)
+	glob = strings.Replace(glob, `\*`, ".*", -1)
+	glob = strings.Replace(glob, `\?`, ".", -1)
+	return regexp.Compile("^" + glob + "$")
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"envoy", "envoy", true},
+		{"envoy", "envoy-proxy", false},
+		{"envoy*", "envoy-proxy", true},
+		{"envoy*", "my-envoy", false},
+		{"*envoy*", "my-envoy-proxy", true},
+		{"*fluent-bit*", "public.ecr.aws/aws-observability/aws-for-fluent-bit:stable", true},
+		{"*/aws-xray-daemon:*", "amazon/aws-xray-daemon:3.3.7", true},
+		{"*/aws-xray-daemon:*", "aws-xray-daemon:3.3.7", false},
+		{"app-?", "app-1", true},
+		{"app-?", "app-12", false},
+		{"app-?", "app-", false},
+		{"app.1", "app.1", true},
+		{"app.1", "app-1", false},
+		{"/^(envoy|xray)$/", "xray", true},
+		{"/^(envoy|xray)$/", "xray-daemon", false},
+		{"/xray/", "aws-xray-daemon", true},
+		{"/", "/", true},
+		{"/[/", "[", false},
+	}
+	for _, c := range cases {
+		if got := MatchPattern(c.pattern, c.s); got != c.want {
+			t.Errorf("MatchPattern(%q, %q): got %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	labels := map[string]string{"team": "payments", "tier": ""}
+	cases := []struct {
+		rule Rule
+		want bool
+	}{
+		{Rule{Name: "app"}, true},
+		{Rule{Name: "app", Image: "nginx:*"}, true},
+		{Rule{Name: "app", Image: "envoy*"}, false},
+		{Rule{Label: "team"}, true},
+		{Rule{Label: "tier"}, true},
+		{Rule{Label: "owner"}, false},
+		{Rule{Label: "team=pay*"}, true},
+		{Rule{Label: "team=/^(payments|billing)$/"}, true},
+		{Rule{Label: "team=billing"}, false},
+		{Rule{Label: "tier="}, true},
+		{Rule{Label: "owner="}, false},
+		{Rule{Name: "app", Label: "team=billing"}, false},
+	}
+	for _, c := range cases {
+		if got := c.rule.Matches("app", "nginx:1.25", labels); got != c.want {
+			t.Errorf("%+v: got %v, want %v", c.rule, got, c.want)
+		}
+	}
+}
+
+func TestRuleUnmarshalJSON(t *testing.T) {
+	var rules []Rule
+	if err := json.Unmarshal([]byte(`["envoy*", {"image": "*xray*", "label": "team=x"}]`), &rules); err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{{Name: "envoy*"}, {Image: "*xray*", Label: "team=x"}}
+	if len(rules) != len(want) || rules[0] != want[0] || rules[1] != want[1] {
+		t.Errorf("got %+v, want %+v", rules, want)
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	cases := []struct {
+		name string
+		rule Rule
+		// want is the start of the only expected error, none if empty
+		want string
+	}{
+		{name: "glob", rule: Rule{Name: "envoy*", Image: "*/xray:?"}},
+		{name: "label", rule: Rule{Label: "team=pay*"}},
+		{name: "empty", want: "filters.exclude[0]: must set at least one of name, image and label"},
+		{name: "regex", rule: Rule{Name: "/[/"}, want: "filters.exclude[0].name: invalid regular expression"},
+		{name: "label regex", rule: Rule{Label: "team=/(/"}, want: "filters.exclude[0].label: invalid regular expression"},
+		{name: "label key", rule: Rule{Label: "=payments"}, want: "filters.exclude[0].label: must start with the label key"},
+	}
+	for _, c := range cases {
+		cfg := Default()
+		cfg.Filters.Exclude = []Rule{c.rule}
+		errs := validate(cfg)
+		switch {
+		case c.want == "" && len(errs) > 0:
+			t.Errorf("%s: got the errors %v", c.name, errs)
+		case c.want != "" && (len(errs) != 1 || !strings.HasPrefix(errs[0], c.want)):
+			t.Errorf("%s: got the errors %v, want %s", c.name, errs, c.want)
+		}
+	}
+}
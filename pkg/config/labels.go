@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelPrefix prefixes the Docker labels overriding the configuration of the
+// container they are on:
+//
+//	ecs-taskmetadata-cloudwatch.metrics=cpu,memory  replaces Metrics
+//	ecs-taskmetadata-cloudwatch.namespace=Foo       replaces Namespace
+//	ecs-taskmetadata-cloudwatch.dimensions.Team=x   adds or replaces the
+//	                                                static dimension Team
+const LabelPrefix = "ecs-taskmetadata-cloudwatch."
+
+const (
+	labelMetrics    = LabelPrefix + "metrics"
+	labelNamespace  = LabelPrefix + "namespace"
+	labelDimensions = LabelPrefix + "dimensions."
+)
+
+// ForContainer returns the configuration of a container labeled with labels:
+// c overridden by the labels starting with LabelPrefix, unless ContainerLabels
+// is disabled. A label which would make the configuration invalid is ignored
+// and reported in the returned errors.
+func (c *Config) ForContainer(labels map[string]string) (*Config, []error) {
+	if !c.ContainerLabels {
+		return c, nil
+	}
+	var keys []string
+	for k := range labels {
+		if strings.HasPrefix(k, LabelPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return c, nil
+	}
+	sort.Strings(keys)
+
+	merged := *c
+	var errs []error
+	for _, k := range keys {
+		next := merged
+		value := labels[k]
+		switch {
+		case k == labelMetrics:
+			next.Metrics = splitList(value)
+		case k == labelNamespace:
+			next.Namespace = value
+		case strings.HasPrefix(k, labelDimensions):
+			static := make(map[string]string, len(merged.Dimensions.Static)+1)
+			for name, v := range merged.Dimensions.Static {
+				static[name] = v
+			}
+			static[strings.TrimPrefix(k, labelDimensions)] = value
+			next.Dimensions.Static = static
+		default:
+			errs = append(errs, fmt.Errorf("label %s: unknown label, ignored", k))
+			continue
+		}
+		// merged is valid, so any problem comes from this label
+		if problems := next.Validate(); len(problems) > 0 {
+			errs = append(errs, fmt.Errorf("label %s: %s, ignored", k, problems[0].Message))
+			continue
+		}
+		merged = next
+	}
+	return &merged, errs
+}
+
+// splitList returns the comma-separated items of s
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
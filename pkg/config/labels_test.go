@@ -0,0 +1,109 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForContainer(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		// check returns what is wrong with the configuration, if anything
+		check func(*Config) string
+		errs  []string
+	}{
+		{
+			name:   "no labels",
+			labels: map[string]string{"team": "payments"},
+		},
+		{
+			name:   "metrics",
+			labels: map[string]string{LabelPrefix + "metrics": "cpu, memory,"},
+			check: func(c *Config) string {
+				if !reflect.DeepEqual(c.Metrics, []string{"cpu", "memory"}) {
+					return "metrics not replaced"
+				}
+				return ""
+			},
+		},
+		{
+			name: "namespace and dimension",
+			labels: map[string]string{
+				LabelPrefix + "namespace":       "Payments",
+				LabelPrefix + "dimensions.Team": "payments",
+			},
+			check: func(c *Config) string {
+				if c.Namespace != "Payments" || c.Dimensions.Static["Team"] != "payments" {
+					return "namespace or dimension not replaced"
+				}
+				return ""
+			},
+		},
+		{
+			name:   "unknown metric",
+			labels: map[string]string{LabelPrefix + "metrics": "cpu,disk"},
+			errs:   []string{`label ecs-taskmetadata-cloudwatch.metrics: unknown metric "disk", must be one of cpu, memory, cpuUser, cpuKernel, cpuPerCore, storage, ephemeralStorage, ignored`},
+		},
+		{
+			name:   "empty namespace",
+			labels: map[string]string{LabelPrefix + "namespace": ""},
+			errs:   []string{"label ecs-taskmetadata-cloudwatch.namespace: must not be empty, ignored"},
+		},
+		{
+			name:   "unknown label",
+			labels: map[string]string{LabelPrefix + "interval": "1s"},
+			errs:   []string{"label ecs-taskmetadata-cloudwatch.interval: unknown label, ignored"},
+		},
+		{
+			name: "valid labels kept",
+			labels: map[string]string{
+				LabelPrefix + "metrics":   "bogus",
+				LabelPrefix + "namespace": "Payments",
+			},
+			check: func(c *Config) string {
+				if c.Namespace != "Payments" {
+					return "namespace not replaced"
+				}
+				return ""
+			},
+			errs: []string{`label ecs-taskmetadata-cloudwatch.metrics: unknown metric "bogus", must be one of cpu, memory, cpuUser, cpuKernel, cpuPerCore, storage, ephemeralStorage, ignored`},
+		},
+	}
+	for _, c := range cases {
+		cfg := Default()
+		cfg.ContainerLabels = true
+		got, errs := cfg.ForContainer(c.labels)
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		if !reflect.DeepEqual(msgs, c.errs) {
+			t.Errorf("%s: got the errors %q, want %q", c.name, msgs, c.errs)
+		}
+		if c.check != nil {
+			if problem := c.check(got); problem != "" {
+				t.Errorf("%s: %s", c.name, problem)
+			}
+		} else if !reflect.DeepEqual(got, cfg) {
+			t.Errorf("%s: got %+v, want the configuration unchanged", c.name, got)
+		}
+		if problems := got.Validate(); len(problems) > 0 {
+			t.Errorf("%s: got an invalid configuration: %v", c.name, problems)
+		}
+		want := Default()
+		want.ContainerLabels = true
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: the configuration of the task was modified", c.name)
+		}
+	}
+}
+
+func TestForContainerDisabled(t *testing.T) {
+	cfg := Default()
+	cfg.ContainerLabels = false
+	got, errs := cfg.ForContainer(map[string]string{LabelPrefix + "namespace": "Payments"})
+	if got != cfg || errs != nil {
+		t.Errorf("got %+v and %v, want the configuration unchanged", got, errs)
+	}
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// FileEnvVar names the environment variable holding the path of the
+	// configuration file
+	FileEnvVar = "TASKMETADATA_CLOUDWATCH_CONFIG_FILE"
+	// InlineEnvVar names the environment variable holding the configuration
+	// itself. Injecting an SSM parameter into it with the `secrets` of the
+	// container definition keeps the configuration in Parameter Store.
+	InlineEnvVar = "TASKMETADATA_CLOUDWATCH_CONFIG"
+)
+
+// Load returns the configuration read from path, or when path is empty from
+// the file named by FileEnvVar or the content of InlineEnvVar, in this order.
+// It returns Default() if none of them is set.
+func Load(path string) (*Config, error) {
+	data, source, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return Default(), nil
+	}
+	return Parse(data, source)
+}
+
+// Read returns the raw configuration Load would parse, as JSON, and a
+// description of where it came from, or nil if no configuration is set. A
+// .yaml or .yml file, or an inline configuration which isn't a JSON object,
+// is YAML and converted to JSON keeping its lines.
+func Read(path string) ([]byte, string, error) {
+	if path = FilePath(path); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to read the configuration: %v", err)
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			return fromYAML(data, path)
+		}
+		return data, path, nil
+	}
+	if inline := os.Getenv(InlineEnvVar); inline != "" {
+		source := "$" + InlineEnvVar
+		if !strings.HasPrefix(strings.TrimSpace(inline), "{") {
+			return fromYAML([]byte(inline), source)
+		}
+		return []byte(inline), source, nil
+	}
+	return nil, "", nil
+}
+
+// fromYAML returns the JSON of the YAML configuration data read from source
+func fromYAML(data []byte, source string) ([]byte, string, error) {
+	data, err := yamlToJSON(data)
+	if err != nil {
+		return nil, "", &ValidationError{Source: source, Errors: []FieldError{err.(FieldError)}}
+	}
+	return data, source, nil
+}
+
+// FilePath returns the path of the configuration file Load reads, path or
+// the value of FileEnvVar, or "" if the configuration isn't read from a file
+func FilePath(path string) string {
+	if path == "" {
+		return os.Getenv(FileEnvVar)
+	}
+	return path
+}
+
+// Parse returns the configuration in data, a JSON document, on top of the
+// defaults. When data is invalid it returns a *ValidationError reporting the
+// line of every problem; source names data in the error.
+func Parse(data []byte, source string) (*Config, error) {
+	pos := locate(data)
+	var errs []FieldError
+	var cfg *Config
+	for {
+		cfg = Default()
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(cfg)
+		if err == nil {
+			break
+		}
+		// An invalid duration stops the decoding: it is reported then
+		// replaced with null, which keeps its default, to decode the rest
+		e, ok := err.(*durationError)
+		if !ok {
+			return nil, &ValidationError{Source: source, Errors: append(errs, decodeError(err, pos))}
+		}
+		path, offset := pos.durationField(e.raw)
+		if path == "" {
+			return nil, &ValidationError{Source: source, Errors: append(errs, decodeError(err, pos))}
+		}
+		errs = append(errs, FieldError{Path: path, Line: pos.line(offset), Message: e.Error()})
+		data = replaceWithNull(data, offset, len(e.raw))
+		pos = locate(data)
+	}
+	cfg.applyDefaults()
+
+	for _, e := range cfg.Validate() {
+		e.Line = pos.lineOf(e.Path)
+		errs = append(errs, e)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	if len(errs) > 0 {
+		return nil, &ValidationError{Source: source, Errors: errs}
+	}
+	return cfg, nil
+}
+
+// durationFields are the JSON names of the Duration fields of Config
+var durationFields = func() []string {
+	var names []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Type == reflect.TypeOf(Duration(0)) {
+			names = append(names, strings.Split(f.Tag.Get("json"), ",")[0])
+		}
+	}
+	return names
+}()
+
+// durationField returns the path and offset of the Duration field whose JSON
+// text is raw, or "" if there is none
+func (p *positions) durationField(raw []byte) (string, int) {
+	for _, path := range durationFields {
+		offset, ok := p.offsets[path]
+		if ok && offset+len(raw) <= len(p.data) && bytes.Equal(p.data[offset:offset+len(raw)], raw) {
+			return path, offset
+		}
+	}
+	return "", 0
+}
+
+// replaceWithNull returns data with the n bytes at offset replaced with null,
+// keeping their line breaks so that the lines of what follows don't change
+func replaceWithNull(data []byte, offset, n int) []byte {
+	replaced := append([]byte(nil), data[:offset]...)
+	replaced = append(replaced, "null"...)
+	replaced = append(replaced, bytes.Repeat([]byte("\n"), bytes.Count(data[offset:offset+n], []byte("\n")))...)
+	return append(replaced, data[offset+n:]...)
+}
+
+// decodeError turns an error of encoding/json into a FieldError with the line
+// it happened at, as far as it can be told
+func decodeError(err error, pos *positions) FieldError {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return FieldError{Path: "(syntax)", Line: pos.line(int(e.Offset)), Message: e.Error()}
+	case *json.UnmarshalTypeError:
+		path := e.Field
+		if path == "" {
+			path = "(root)"
+		}
+		return FieldError{
+			Path:    path,
+			Line:    pos.line(int(e.Offset)),
+			Message: fmt.Sprintf("must be %s, got %s", e.Type, e.Value),
+		}
+	case *durationError:
+		return FieldError{Path: "(duration)", Line: pos.lineOfValue(e.raw), Message: e.Error()}
+	}
+
+	// encoding/json has no type for unknown fields
+	const unknownField = "json: unknown field "
+	if msg := err.Error(); strings.HasPrefix(msg, unknownField) {
+		name := strings.Trim(strings.TrimPrefix(msg, unknownField), `"`)
+		return FieldError{Path: name, Line: pos.lineOfKey(name), Message: "unknown field"}
+	}
+	return FieldError{Path: "(root)", Message: err.Error()}
+}
+
+// sortedKeys returns the keys of m in order, for stable error messages
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lines returns the "line N: path: message" of the errors of a
+// *ValidationError, or fails the test if err is something else
+func lines(t *testing.T, err error) []string {
+	t.Helper()
+	v, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a *ValidationError", err)
+	}
+	var got []string
+	for _, e := range v.Errors {
+		got = append(got, e.Error())
+	}
+	return got
+}
+
+// writeFile writes content to name in a temporary directory and returns its
+// path, and a function removing it
+func writeFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadYAML(t *testing.T) {
+	path, remove := writeFile(t, "config.yaml", `# every 30 seconds
+interval: 30s
+metrics: [cpu, memory, storage]
+dimensions:
+  static:
+    Environment: production
+sinks:
+  - type: emf
+`)
+	defer remove()
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval.Duration() != 30*time.Second {
+		t.Errorf("got the interval %v", cfg.Interval.Duration())
+	}
+	if !reflect.DeepEqual(cfg.Metrics, []string{MetricCPU, MetricMemory, MetricStorage}) {
+		t.Errorf("got the metrics %v", cfg.Metrics)
+	}
+	if cfg.Dimensions.Static["Environment"] != "production" {
+		t.Errorf("got the static dimensions %v", cfg.Dimensions.Static)
+	}
+	// The defaults of the sinks apply
+	if len(cfg.Sinks) != 1 || cfg.Sinks[0].Type != SinkEMF || cfg.Sinks[0].MaxBuffered != defaultMaxBuffered {
+		t.Errorf("got the sinks %+v", cfg.Sinks)
+	}
+}
+
+func TestLoadYAMLErrors(t *testing.T) {
+	cases := []struct {
+		name, content string
+		want          []string
+	}{
+		{
+			name: "invalid values",
+			content: `metrics:
+  - cpu
+  - disk
+sinks:
+  - type: kinesis
+`,
+			want: []string{
+				`line 3: metrics[1]: unknown metric "disk", must be one of cpu, memory, cpuUser, cpuKernel, cpuPerCore, storage, ephemeralStorage`,
+				`line 5: sinks[0].type: unknown sink type "kinesis", must be one of cloudwatch, emf`,
+			},
+		},
+		{
+			name:    "unknown field",
+			content: "namespace: Custom\n\nintervals: 10s\n",
+			want:    []string{"line 3: intervals: unknown field"},
+		},
+		{
+			name:    "wrong type",
+			content: "namespace: Custom\nselfMetrics: often\n",
+			want:    []string{"line 2: selfMetrics: must be bool, got string"},
+		},
+		{
+			name:    "syntax",
+			content: "namespace: Custom\nmetrics:\n  - cpu\n   - memory: [\n",
+			want:    []string{"line 4: (syntax): mapping values are not allowed in this context"},
+		},
+	}
+	for _, c := range cases {
+		path, remove := writeFile(t, "config.yml", c.content)
+		_, err := Load(path)
+		remove()
+		if got := lines(t, err); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got the errors\n%s\nwant\n%s", c.name, strings.Join(got, "\n"), strings.Join(c.want, "\n"))
+		}
+	}
+}
+
+func TestReadInline(t *testing.T) {
+	defer os.Unsetenv(InlineEnvVar)
+	for _, inline := range []string{`{"namespace": "Custom"}`, " {\"namespace\": \"Custom\"}", "namespace: Custom", "---\nnamespace: Custom\n"} {
+		os.Setenv(InlineEnvVar, inline)
+		cfg, err := Load("")
+		if err != nil {
+			t.Errorf("%q: %v", inline, err)
+			continue
+		}
+		if cfg.Namespace != "Custom" {
+			t.Errorf("%q: got the namespace %q", inline, cfg.Namespace)
+		}
+	}
+
+	// Empty YAML is the defaults
+	os.Setenv(InlineEnvVar, "# nothing\n")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("got %+v, want the defaults", cfg)
+	}
+}
+
+func TestParseDurationErrors(t *testing.T) {
+	cases := []struct {
+		name, data string
+		want       []string
+	}{
+		{
+			name: "validated after",
+			data: `{"interval":"10x","metrics":["cpu","disk"]}`,
+			want: []string{
+				`line 1: interval: invalid duration "10x": time: unknown unit "x" in duration "10x"`,
+				`line 1: metrics[1]: unknown metric "disk", must be one of cpu, memory, cpuUser, cpuKernel, cpuPerCore, storage, ephemeralStorage`,
+			},
+		},
+		{
+			name: "several",
+			data: `{
+  "interval": "10x",
+  "startupTimeout": {
+    "minutes": 1
+  },
+  "shutdownTimeout": 25,
+  "namespace": ""
+}`,
+			want: []string{
+				`line 2: interval: invalid duration "10x": time: unknown unit "x" in duration "10x"`,
+				`line 3: startupTimeout: invalid duration: must be a string such as "10s"`,
+				`line 6: shutdownTimeout: invalid duration: must be a string such as "10s"`,
+				`line 7: namespace: must not be empty`,
+			},
+		},
+		{
+			// The same text in another field isn't mistaken for the duration
+			name: "same text",
+			data: "{\n  \"namespace\": \"10x\",\n  \"interval\": \"10x\"\n}",
+			want: []string{`line 3: interval: invalid duration "10x": time: unknown unit "x" in duration "10x"`},
+		},
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c.data), "test")
+		if got := lines(t, err); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got the errors\n%s\nwant\n%s", c.name, strings.Join(got, "\n"), strings.Join(c.want, "\n"))
+		}
+	}
+
+	// null keeps the default
+	cfg, err := Parse([]byte(`{"interval": null}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != Default().Interval {
+		t.Errorf("got the interval %v", cfg.Interval)
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// positions maps the JSON path of every member and element of a document,
+// e.g. "sinks[0].type", to the offset its value starts at. It is only used
+// to tell which line a validation error is about, so the document is expected
+// to be valid JSON and anything unexpected just stops the scan.
+type positions struct {
+	data    []byte
+	offsets map[string]int
+	// order keeps the paths in document order, to find values by content
+	order []string
+}
+
+func locate(data []byte) *positions {
+	p := &positions{data: data, offsets: make(map[string]int)}
+	p.value(p.skipSpace(0), "")
+	return p
+}
+
+// line returns the 1-based line of offset
+func (p *positions) line(offset int) int {
+	if offset > len(p.data) {
+		offset = len(p.data)
+	}
+	return bytes.Count(p.data[:offset], []byte("\n")) + 1
+}
+
+// lineOf returns the line of the value at path, or 0 if it isn't found. When
+// path isn't in the document, e.g. a missing field, the line of its closest
+// parent is returned.
+func (p *positions) lineOf(path string) int {
+	for path != "" {
+		if offset, ok := p.offsets[path]; ok {
+			return p.line(offset)
+		}
+		path = parentPath(path)
+	}
+	return 0
+}
+
+// lineOfKey returns the line of the first member named key, or 0
+func (p *positions) lineOfKey(key string) int {
+	for _, path := range p.order {
+		if path == key || len(path) > len(key) && path[len(path)-len(key)-1:] == "."+key {
+			return p.line(p.offsets[path])
+		}
+	}
+	return 0
+}
+
+// lineOfValue returns the line of the first scalar value whose JSON text is
+// raw, or 0
+func (p *positions) lineOfValue(raw []byte) int {
+	for _, path := range p.order {
+		offset := p.offsets[path]
+		if offset+len(raw) <= len(p.data) && bytes.Equal(p.data[offset:offset+len(raw)], raw) {
+			return p.line(offset)
+		}
+	}
+	return 0
+}
+
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch path[i] {
+		case '.':
+			return path[:i]
+		case '[':
+			return path[:i]
+		}
+	}
+	return ""
+}
+
+func (p *positions) record(path string, offset int) {
+	if path == "" {
+		return
+	}
+	if _, ok := p.offsets[path]; !ok {
+		p.order = append(p.order, path)
+	}
+	p.offsets[path] = offset
+}
+
+func (p *positions) skipSpace(i int) int {
+	for i < len(p.data) {
+		switch p.data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// value scans the value starting at i and returns the offset right after it
+func (p *positions) value(i int, path string) int {
+	if i >= len(p.data) {
+		return i
+	}
+	p.record(path, i)
+	switch p.data[i] {
+	case '{':
+		return p.object(i+1, path)
+	case '[':
+		return p.array(i+1, path)
+	case '"':
+		return p.str(i)
+	default:
+		start := i
+		for i < len(p.data) && bytes.IndexByte([]byte(",}] \t\n\r"), p.data[i]) < 0 {
+			i++
+		}
+		if i == start {
+			// not a value, e.g. a syntax error: stop scanning
+			return len(p.data)
+		}
+		return i
+	}
+}
+
+func (p *positions) object(i int, path string) int {
+	for {
+		i = p.skipSpace(i)
+		if i >= len(p.data) || p.data[i] == '}' {
+			return i + 1
+		}
+		if p.data[i] == ',' {
+			i++
+			continue
+		}
+		if p.data[i] != '"' {
+			return len(p.data)
+		}
+		end := p.str(i)
+		key, err := strconv.Unquote(string(p.data[i:end]))
+		if err != nil {
+			return len(p.data)
+		}
+		i = p.skipSpace(end)
+		if i >= len(p.data) || p.data[i] != ':' {
+			return len(p.data)
+		}
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		i = p.value(p.skipSpace(i+1), child)
+	}
+}
+
+func (p *positions) array(i int, path string) int {
+	for n := 0; ; {
+		i = p.skipSpace(i)
+		if i >= len(p.data) || p.data[i] == ']' {
+			return i + 1
+		}
+		if p.data[i] == ',' {
+			i++
+			continue
+		}
+		i = p.value(i, path+"["+strconv.Itoa(n)+"]")
+		n++
+	}
+}
+
+// str returns the offset right after the string starting at i
+func (p *positions) str(i int) int {
+	for i++; i < len(p.data); i++ {
+		switch p.data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return i
+}
@@ -0,0 +1,86 @@
+package config
+
+import "testing"
+
+func TestLocate(t *testing.T) {
+	data := []byte(`{
+  "interval": "10s",
+  "dimensions": {
+    "static": {
+      "Team": "payments"
+    }
+  },
+  "sinks": [
+    {"type": "cloudwatch"},
+    {
+      "type": "emf",
+      "escaped\"key": 1
+    }
+  ]
+}`)
+	pos := locate(data)
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"interval", 2},
+		{"dimensions", 3},
+		{"dimensions.static.Team", 5},
+		{"sinks[0].type", 9},
+		{"sinks[1]", 10},
+		{"sinks[1].type", 11},
+		// missing paths are on the line of their closest parent
+		{"dimensions.static.Owner", 4},
+		{"sinks[1].maxBuffered", 10},
+		{"sinks[2].type", 8},
+		{"namespace", 0},
+	}
+	for _, c := range cases {
+		if got := pos.lineOf(c.path); got != c.want {
+			t.Errorf("lineOf(%q): got %d, want %d", c.path, got, c.want)
+		}
+	}
+
+	keys := []struct {
+		key  string
+		want int
+	}{
+		{"interval", 2},
+		{"Team", 5},
+		{"type", 9},
+		{`escaped"key`, 12},
+		{"ype", 0},
+		{"unknown", 0},
+	}
+	for _, c := range keys {
+		if got := pos.lineOfKey(c.key); got != c.want {
+			t.Errorf("lineOfKey(%q): got %d, want %d", c.key, got, c.want)
+		}
+	}
+}
+
+func TestParseUnknownNestedField(t *testing.T) {
+	_, err := Parse([]byte(`{
+  "interval": "10s",
+  "source": {
+    "type": "metadata",
+    "dockerSockets": "/var/run/docker.sock"
+  }
+}`), "test")
+	got := lines(t, err)
+	want := "line 5: dockerSockets: unknown field"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLocateInvalidJSON(t *testing.T) {
+	// The scan stops at the syntax error, keeping what comes before
+	pos := locate([]byte("{\n  \"interval\": \"10s\",\n  \"metrics\": [cpu]\n}"))
+	if got := pos.lineOf("interval"); got != 2 {
+		t.Errorf("lineOf(interval): got %d, want 2", got)
+	}
+	if got := pos.lineOf("metrics[0]"); got != 3 {
+		t.Errorf("lineOf(metrics[0]): got %d, want 3", got)
+	}
+}
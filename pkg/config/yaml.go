@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// yamlErrorLine finds the line in the errors of the YAML parser
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// yamlToJSON converts a YAML configuration to the same JSON document, every
+// value starting on the line it has in the YAML, so that Parse reports the
+// lines of the YAML. An empty document is an empty object.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		e := FieldError{Path: "(syntax)", Message: err.Error()}
+		if m := yamlErrorLine.FindStringSubmatch(e.Message); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Message = e.Message[len(m[0]):]
+		}
+		return nil, e
+	}
+	if len(doc.Content) == 0 {
+		return []byte("{}"), nil
+	}
+	w := &jsonWriter{line: 1}
+	if err := w.node(doc.Content[0]); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// jsonWriter writes YAML nodes as JSON, keeping track of the current line
+type jsonWriter struct {
+	buf  bytes.Buffer
+	line int
+}
+
+// pad starts a new line until line is reached. Lines never go back, e.g. for
+// an alias, which just stays on the current line.
+func (w *jsonWriter) pad(line int) {
+	for w.line < line {
+		w.buf.WriteByte('\n')
+		w.line++
+	}
+}
+
+func (w *jsonWriter) node(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return w.node(n.Alias)
+	case yaml.MappingNode:
+		w.pad(n.Line)
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			key := n.Content[i]
+			if key.Kind != yaml.ScalarNode {
+				return FieldError{Path: "(syntax)", Line: key.Line, Message: "mapping keys must be strings"}
+			}
+			w.pad(key.Line)
+			if err := w.scalar(key.Value); err != nil {
+				return err
+			}
+			w.buf.WriteByte(':')
+			if err := w.node(n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteByte('}')
+	case yaml.SequenceNode:
+		w.pad(n.Line)
+		w.buf.WriteByte('[')
+		for i, child := range n.Content {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			if err := w.node(child); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteByte(']')
+	case yaml.ScalarNode:
+		w.pad(n.Line)
+		switch n.ShortTag() {
+		case "!!null":
+			w.buf.WriteString("null")
+		case "!!bool", "!!int", "!!float":
+			var v interface{}
+			if err := n.Decode(&v); err != nil {
+				return FieldError{Path: "(syntax)", Line: n.Line, Message: err.Error()}
+			}
+			return w.scalar(v)
+		default:
+			// Strings, and timestamps or binaries as written
+			return w.scalar(n.Value)
+		}
+	default:
+		return FieldError{Path: "(syntax)", Line: n.Line, Message: fmt.Sprintf("unexpected YAML node of kind %d", n.Kind)}
+	}
+	return nil
+}
+
+func (w *jsonWriter) scalar(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return FieldError{Path: "(syntax)", Line: w.line, Message: fmt.Sprintf("unable to convert %v to JSON: %v", v, err)}
+	}
+	w.buf.Write(b)
+	return nil
+}
@@ -0,0 +1,148 @@
+package cw
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+)
+
+// RevisionDimensions returns the dimensions the metrics of a container are
+// published with a second time to compare task definition revisions: they
+// aggregate every task of the revision, whatever its cluster or service
+func RevisionDimensions(family, revision, container string) []*cloudwatch.Dimension {
+	return []*cloudwatch.Dimension{
+		Dimension(config.DimensionTaskDefinitionFamily, family),
+		Dimension(config.DimensionTaskDefinitionRevision, revision),
+		Dimension(config.DimensionContainerDefinitionName, container),
+	}
+}
+
+// CompareInput selects the revision metrics to compare
+type CompareInput struct {
+	Namespace string
+	Family    string
+	// Container is the name of the container in the task definition
+	Container string
+	Baseline  string
+	Candidate string
+	// Start and End delimit the period the averages are computed over
+	Start time.Time
+	End   time.Time
+	// Threshold is the maximum increase, in percentage points, of the
+	// candidate's average over the baseline's
+	Threshold float64
+}
+
+// Comparison is the result of comparing one metric of two revisions
+type Comparison struct {
+	MetricName string
+	// Baseline and Candidate are the averages, nil if there is no data
+	Baseline  *float64
+	Candidate *float64
+	Pass      bool
+}
+
+// Delta returns the increase of the candidate's average over the baseline's,
+// or 0 if either is missing
+func (c Comparison) Delta() float64 {
+	if c.Baseline == nil || c.Candidate == nil {
+		return 0
+	}
+	return *c.Candidate - *c.Baseline
+}
+
+// CompareRevisions pulls the average CPU and memory utilization of two
+// revisions back from CloudWatch. A metric passes if both revisions have data
+// and the candidate's average doesn't exceed the baseline's by more than the
+// threshold.
+func CompareRevisions(ctx context.Context, client *cloudwatch.CloudWatch, in CompareInput) ([]Comparison, error) {
+	metrics := []string{metricNameCPUUtilization, metricNameMemoryUtilization}
+	revisions := []string{in.Baseline, in.Candidate}
+
+	// A single period covering the whole range, in whole minutes
+	period := int64(in.End.Sub(in.Start).Minutes()) * 60
+	if period < 60 {
+		return nil, fmt.Errorf("unable to compare over %v, the period must be at least 1m", in.End.Sub(in.Start))
+	}
+
+	// The sums and sample counts rather than the averages, so that periods
+	// weigh by their number of samples
+	stats := []struct{ prefix, stat string }{
+		{"s", cloudwatch.StatisticSum},
+		{"n", cloudwatch.StatisticSampleCount},
+	}
+	var queries []*cloudwatch.MetricDataQuery
+	for i, metric := range metrics {
+		for j, revision := range revisions {
+			for _, stat := range stats {
+				queries = append(queries, &cloudwatch.MetricDataQuery{
+					Id: aws.String(fmt.Sprintf("%s%d_%d", stat.prefix, i, j)),
+					MetricStat: &cloudwatch.MetricStat{
+						Metric: &cloudwatch.Metric{
+							Namespace:  aws.String(in.Namespace),
+							MetricName: aws.String(metric),
+							Dimensions: RevisionDimensions(in.Family, revision, in.Container),
+						},
+						Period: aws.Int64(period),
+						Stat:   aws.String(stat.stat),
+					},
+				})
+			}
+		}
+	}
+
+	values := make(map[string][]*float64)
+	input := &cloudwatch.GetMetricDataInput{
+		MetricDataQueries: queries,
+		StartTime:         aws.Time(in.Start),
+		EndTime:           aws.Time(in.End),
+	}
+	for {
+		out, err := client.GetMetricDataWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the metric data: %v", err)
+		}
+		for _, r := range out.MetricDataResults {
+			id := aws.StringValue(r.Id)
+			values[id] = append(values[id], r.Values...)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	comparisons := make([]Comparison, len(metrics))
+	for i, metric := range metrics {
+		c := Comparison{
+			MetricName: metric,
+			Baseline:   average(values[fmt.Sprintf("s%d_0", i)], values[fmt.Sprintf("n%d_0", i)]),
+			Candidate:  average(values[fmt.Sprintf("s%d_1", i)], values[fmt.Sprintf("n%d_1", i)]),
+		}
+		c.Pass = c.Baseline != nil && c.Candidate != nil && c.Delta() <= in.Threshold
+		comparisons[i] = c
+	}
+	return comparisons, nil
+}
+
+// average returns the average of the samples whose sums and counts are given
+// per period, or nil if there are none. The range may span more than one
+// period when it isn't aligned on the minute.
+func average(sums, counts []*float64) *float64 {
+	var sum, count float64
+	for _, v := range sums {
+		sum += aws.Float64Value(v)
+	}
+	for _, v := range counts {
+		count += aws.Float64Value(v)
+	}
+	if count == 0 {
+		return nil
+	}
+	return aws.Float64(sum / count)
+}
@@ -0,0 +1,34 @@
+package cw
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestAverage(t *testing.T) {
+	cases := []struct {
+		name          string
+		sums, counts  []float64
+		want          float64
+		wantNoAverage bool
+	}{
+		{name: "single period", sums: []float64{300}, counts: []float64{6}, want: 50},
+		// 10 samples at 10% then 2 at 70%: 20%, not the 40% average of the
+		// two periods' averages
+		{name: "uneven periods", sums: []float64{100, 140}, counts: []float64{10, 2}, want: 20},
+		{name: "no data", wantNoAverage: true},
+		{name: "no samples", sums: []float64{0}, counts: []float64{0}, wantNoAverage: true},
+	}
+	for _, c := range cases {
+		got := average(aws.Float64Slice(c.sums), aws.Float64Slice(c.counts))
+		switch {
+		case c.wantNoAverage && got != nil:
+			t.Errorf("%s: got %v, want no average", c.name, *got)
+		case !c.wantNoAverage && got == nil:
+			t.Errorf("%s: got no average, want %v", c.name, c.want)
+		case got != nil && *got != c.want:
+			t.Errorf("%s: got %v, want %v", c.name, *got, c.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package cw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
+)
+
+const (
+	// metricMonthlyPrice is the price in USD of a custom metric published
+	// all month long, in the first tier of us-east-1
+	metricMonthlyPrice = 0.30
+	// requestPrice is the price in USD of a PutMetricData request
+	requestPrice = 0.01 / 1000
+
+	month = 30 * 24 * time.Hour
+)
+
+// DryRunSink is a Sink writing the PutMetricData requests it would send to
+// Namespace as JSON lines, batched the way BufferedSink sends them, along with
+// their estimated cost. The lines are appended to the file Path, or written to
+// Out if Path is empty.
+type DryRunSink struct {
+	Namespace string
+	Path      string
+	Out       io.Writer
+	// Interval is how often the same requests are made, to estimate their
+	// monthly cost
+	Interval time.Duration
+
+	mu sync.Mutex
+}
+
+// DryRunRecord is a line written by DryRunSink
+type DryRunRecord struct {
+	Time  time.Time                      `json:"time"`
+	Input *cloudwatch.PutMetricDataInput `json:"input"`
+	// EstimatedMonthlyCost is the cost of the metrics of the request, assuming
+	// it is made every interval for a month
+	EstimatedMonthlyCost []MetricCost `json:"estimatedMonthlyCost"`
+}
+
+// MetricCost is the estimated monthly cost of the metrics of a name
+type MetricCost struct {
+	MetricName string `json:"metricName"`
+	// Metrics is the number of metrics, i.e. of dimension combinations, which
+	// CloudWatch charges for one by one
+	Metrics int `json:"metrics"`
+	// USD is the cost of the metrics plus their share of the requests
+	USD float64 `json:"usd"`
+}
+
+// NewDryRunSink returns a DryRunSink writing the requests to namespace, made
+// every interval, to path or to standard output if path is empty
+func NewDryRunSink(namespace, path string, interval time.Duration) *DryRunSink {
+	return &DryRunSink{Namespace: namespace, Path: path, Out: os.Stdout, Interval: interval}
+}
+
+// Name implements Sink
+func (s *DryRunSink) Name() string {
+	return "dryrun:" + s.Namespace
+}
+
+// Put implements Sink
+func (s *DryRunSink) Put(ctx context.Context, data ...*cloudwatch.MetricDatum) error {
+	var lines []byte
+	total := len(data)
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxDatumsPerRequest {
+			n = maxDatumsPerRequest
+		}
+		b, err := json.Marshal(&DryRunRecord{
+			Time: time.Now(),
+			Input: &cloudwatch.PutMetricDataInput{
+				Namespace:  aws.String(s.Namespace),
+				MetricData: data[:n],
+			},
+			EstimatedMonthlyCost: EstimateMonthlyCost(data[:n], s.Interval),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to encode the request: %v", err)
+		}
+		lines = append(append(lines, b...), '\n')
+		data = data[n:]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	err := s.write(lines)
+	result := telemetry.PublishSuccess
+	if err != nil {
+		result = telemetry.PublishFailure
+	}
+	telemetry.Default.ObservePublish(result, total, 0)
+	return err
+}
+
+// Flush implements Sink, nothing is buffered
+func (s *DryRunSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *DryRunSink) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Path == "" {
+		_, err := s.Out.Write(b)
+		return err
+	}
+	// The file is opened on every write so that it can be rotated
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open the dry run file: %v", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write the dry run file: %v", err)
+	}
+	return f.Close()
+}
+
+// EstimateMonthlyCost returns the monthly cost of publishing datums, in a
+// single request, every interval. Each dimension combination of a metric
+// name is charged as a metric, and the requests are shared between the
+// metric names by number of datums. The prices are the ones of the first
+// tier in us-east-1.
+func EstimateMonthlyCost(datums []*cloudwatch.MetricDatum, interval time.Duration) []MetricCost {
+	var requests float64
+	if interval > 0 {
+		requests = float64(month / interval)
+	}
+
+	var names []string
+	series := make(map[string]map[string]bool)
+	counts := make(map[string]int)
+	for _, d := range datums {
+		name := aws.StringValue(d.MetricName)
+		if _, ok := series[name]; !ok {
+			names = append(names, name)
+			series[name] = make(map[string]bool)
+		}
+		series[name][dimensionsKey(d.Dimensions)] = true
+		counts[name]++
+	}
+
+	costs := make([]MetricCost, 0, len(names))
+	for _, name := range names {
+		costs = append(costs, MetricCost{
+			MetricName: name,
+			Metrics:    len(series[name]),
+			USD: float64(len(series[name]))*metricMonthlyPrice +
+				float64(counts[name])/float64(len(datums))*requests*requestPrice,
+		})
+	}
+	return costs
+}
+
+// dimensionsKey identifies a combination of dimensions, whatever their order
+func dimensionsKey(dimensions []*cloudwatch.Dimension) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, dim := range dimensions {
+		pairs = append(pairs, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,120 @@
+package cw
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// emfMetric is a metric definition of the CloudWatch Embedded Metric Format
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit,omitempty"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// WriteEMF writes datums of namespace to w in the CloudWatch Embedded Metric
+// Format, one JSON document per line. Datums sharing their dimensions and
+// timestamp go to the same document. properties are added to every document
+// as is, without being dimensions.
+func WriteEMF(w io.Writer, namespace string, datums []*cloudwatch.MetricDatum, properties map[string]string) error {
+	var keys []string
+	docs := make(map[string]map[string]interface{})
+	for _, d := range datums {
+		names := make([]string, 0, len(d.Dimensions))
+		values := make([]string, 0, len(d.Dimensions))
+		for _, dim := range d.Dimensions {
+			names = append(names, aws.StringValue(dim.Name))
+			values = append(values, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+		}
+		ts := aws.TimeValue(d.Timestamp)
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		key := ts.String() + "/" + strings.Join(values, ",")
+
+		doc, ok := docs[key]
+		if !ok {
+			doc = map[string]interface{}{
+				"_aws": &emfMetadata{
+					Timestamp: ts.UnixNano() / int64(time.Millisecond),
+					CloudWatchMetrics: []emfDirective{
+						{Namespace: namespace, Dimensions: [][]string{names}},
+					},
+				},
+			}
+			for name, value := range properties {
+				doc[name] = value
+			}
+			for _, dim := range d.Dimensions {
+				doc[aws.StringValue(dim.Name)] = aws.StringValue(dim.Value)
+			}
+			docs[key] = doc
+			keys = append(keys, key)
+		}
+		directive := &doc["_aws"].(*emfMetadata).CloudWatchMetrics[0]
+		directive.Metrics = append(directive.Metrics, emfMetric{
+			Name: aws.StringValue(d.MetricName),
+			Unit: aws.StringValue(d.Unit),
+		})
+		doc[aws.StringValue(d.MetricName)] = aws.Float64Value(d.Value)
+	}
+
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		if err := enc.Encode(docs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EMFSink is a Sink writing metric data in the CloudWatch Embedded Metric
+// Format, for the awslogs log driver to turn into metrics. Nothing is
+// buffered, so Flush has nothing to do.
+type EMFSink struct {
+	Namespace string
+	Out       io.Writer
+	// Properties are added to every document, see WriteEMF
+	Properties map[string]string
+
+	mu sync.Mutex
+}
+
+// NewEMFSink returns an EMFSink writing data of namespace to out
+func NewEMFSink(namespace string, out io.Writer, properties map[string]string) *EMFSink {
+	return &EMFSink{Namespace: namespace, Out: out, Properties: properties}
+}
+
+// Name implements Sink
+func (s *EMFSink) Name() string {
+	return "emf:" + s.Namespace
+}
+
+// Put implements Sink
+func (s *EMFSink) Put(ctx context.Context, data ...*cloudwatch.MetricDatum) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return WriteEMF(s.Out, s.Namespace, data, s.Properties)
+}
+
+// Flush implements Sink
+func (s *EMFSink) Flush(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package cw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+)
+
+func TestWriteEMF(t *testing.T) {
+	ts := time.Unix(1546300800, 0)
+	datum := func(name, container string, value float64) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Unit:       aws.String("Percent"),
+			Value:      aws.Float64(value),
+			Timestamp:  aws.Time(ts),
+			Dimensions: []*cloudwatch.Dimension{Dimension("ContainerName", container)},
+		}
+	}
+	datums := []*cloudwatch.MetricDatum{
+		datum("CPUUtilization", "app", 25),
+		datum("MemoryUtilization", "app", 50),
+		datum("CPUUtilization", "sidecar", 1),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEMF(&buf, "ECS/Containers", datums, map[string]string{"CostCenter": "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d documents, want one per container:\n%s", len(lines), buf.String())
+	}
+
+	var doc struct {
+		ContainerName     string
+		CostCenter        string
+		CPUUtilization    float64
+		MemoryUtilization float64
+		AWS               emfMetadata `json:"_aws"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ContainerName != "app" || doc.CostCenter != "1234" || doc.CPUUtilization != 25 || doc.MemoryUtilization != 50 {
+		t.Errorf("got the document %s", lines[0])
+	}
+	if doc.AWS.Timestamp != 1546300800000 || len(doc.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("got the metadata %+v", doc.AWS)
+	}
+	directive := doc.AWS.CloudWatchMetrics[0]
+	// Properties aren't dimensions
+	if directive.Namespace != "ECS/Containers" || len(directive.Dimensions) != 1 ||
+		strings.Join(directive.Dimensions[0], ",") != "ContainerName" || len(directive.Metrics) != 2 {
+		t.Errorf("got the directive %+v", directive)
+	}
+}
+
+func TestEMFSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewEMFSink("ECS/Containers", &buf, map[string]string{"Team": "payments"})
+	datum := &cloudwatch.MetricDatum{
+		MetricName: aws.String("CPUUtilization"),
+		Unit:       aws.String("Percent"),
+		Value:      aws.Float64(25),
+		Dimensions: []*cloudwatch.Dimension{Dimension("ContainerName", "app")},
+	}
+	if err := sink.Put(context.Background(), datum); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("unable to parse %q: %v", buf.String(), err)
+	}
+	if doc["Team"] != "payments" || doc["CPUUtilization"] != 25.0 || doc["ContainerName"] != "app" {
+		t.Errorf("got the document %s", buf.String())
+	}
+}
+
+// TestMetricNames checks the names properties are validated against are the
+// ones put
+func TestMetricNames(t *testing.T) {
+	for _, name := range []string{
+		metricNameMemoryUtilization, metricNameCPUUtilization, metricNameCPUUser, metricNameCPUKernel,
+		metricNameCPUCore, metricNamePrivateWorkingSet, metricNameStorageRead, metricNameStorageWrite,
+		metricNameTaskStopping, metricNameEphemeralStorageUtilized, metricNameEphemeralStorageUtilization,
+	} {
+		found := false
+		for _, known := range config.MetricNames {
+			found = found || known == name
+		}
+		if !found {
+			t.Errorf("%s is missing from config.MetricNames", name)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package cw
 
 import (
+	"context"
+	"strconv"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/docker/docker/api/types"
@@ -8,55 +12,162 @@ import (
 )
 
 const (
-	nameSpace = "ECS/Containers"
-
 	metricNameMemoryUtilization = "MemoryUtilization"
 	metricNameCPUUtilization    = "CPUUtilization"
+	metricNameCPUUser           = "CPUUserUtilization"
+	metricNameCPUKernel         = "CPUKernelUtilization"
+	metricNameCPUCore           = "CPUCoreUtilization"
+	metricNamePrivateWorkingSet = "MemoryPrivateWorkingSet"
+	metricNameStorageRead       = "StorageReadBytes"
+	metricNameStorageWrite      = "StorageWriteBytes"
+	metricNameTaskStopping      = "TaskStopping"
+
+	metricNameEphemeralStorageUtilized    = "EphemeralStorageUtilized"
+	metricNameEphemeralStorageUtilization = "EphemeralStorageUtilization"
 )
 
-func GetMemoryUtilization(stats *types.Stats, clusterName, containerName string) (*cloudwatch.MetricDatum, error) {
+func GetMemoryUtilization(stats *types.Stats, dimensions []*cloudwatch.Dimension) (*cloudwatch.MetricDatum, error) {
 	value := docker.CalculateMemUtilization(stats)
 	d := &cloudwatch.MetricDatum{
 		MetricName: aws.String(metricNameMemoryUtilization),
 		Unit:       aws.String(cloudwatch.StandardUnitPercent),
 		Value:      aws.Float64(value),
-		Dimensions: []*cloudwatch.Dimension{
-			&cloudwatch.Dimension{
-				Name:  aws.String("ClusterName"),
-				Value: aws.String(clusterName),
-			},
-			&cloudwatch.Dimension{
-				Name:  aws.String("ContainerName"),
-				Value: aws.String(containerName),
-			},
-		},
+		Timestamp:  timestamp(stats),
+		Dimensions: dimensions,
 	}
 	return d, nil
 }
 
-func GetCpuUtilization(stats *types.Stats, clusterName, containerName string) (*cloudwatch.MetricDatum, error) {
+func GetCpuUtilization(stats *types.Stats, dimensions []*cloudwatch.Dimension) (*cloudwatch.MetricDatum, error) {
 	value := docker.CalculateCpuUtilization(stats)
 	d := &cloudwatch.MetricDatum{
 		MetricName: aws.String(metricNameCPUUtilization),
 		Unit:       aws.String(cloudwatch.StandardUnitPercent),
 		Value:      aws.Float64(value),
-		Dimensions: []*cloudwatch.Dimension{
-			&cloudwatch.Dimension{
-				Name:  aws.String("ClusterName"),
-				Value: aws.String(clusterName),
-			},
-			&cloudwatch.Dimension{
-				Name:  aws.String("ContainerName"),
-				Value: aws.String(containerName),
-			},
-		},
+		Timestamp:  timestamp(stats),
+		Dimensions: dimensions,
 	}
 	return d, nil
 }
 
-func PutMetrics(client *cloudwatch.CloudWatch, input ...*cloudwatch.MetricDatum) error {
-	_, err := client.PutMetricData(&cloudwatch.PutMetricDataInput{
-		Namespace:  aws.String(nameSpace),
+// GetMemoryPrivateWorkingSet returns the memory used by a Windows container,
+// whose stats have no limit to compute a utilization from
+func GetMemoryPrivateWorkingSet(stats *types.Stats, dimensions []*cloudwatch.Dimension) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(metricNamePrivateWorkingSet),
+		Unit:       aws.String(cloudwatch.StandardUnitBytes),
+		Value:      aws.Float64(float64(stats.MemoryStats.PrivateWorkingSet)),
+		Timestamp:  timestamp(stats),
+		Dimensions: dimensions,
+	}
+}
+
+// GetStorageThroughput returns the bytes per second read from and written to
+// storage since prev, an earlier sample of the same container. It returns nil
+// if the counters went backwards.
+func GetStorageThroughput(stats, prev *types.Stats, dimensions []*cloudwatch.Dimension) (read, write *cloudwatch.MetricDatum) {
+	elapsed := stats.Read.Sub(prev.Read).Seconds()
+	r, w := docker.CalculateStorageBytes(stats)
+	prevR, prevW := docker.CalculateStorageBytes(prev)
+	if elapsed <= 0 || r < prevR || w < prevW {
+		return nil, nil
+	}
+	datum := func(name string, value float64) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Unit:       aws.String(cloudwatch.StandardUnitBytesSecond),
+			Value:      aws.Float64(value),
+			Timestamp:  timestamp(stats),
+			Dimensions: dimensions,
+		}
+	}
+	return datum(metricNameStorageRead, float64(r-prevR)/elapsed), datum(metricNameStorageWrite, float64(w-prevW)/elapsed)
+}
+
+// GetCpuModeUtilization returns the CPU utilization in user mode and in kernel
+// mode
+func GetCpuModeUtilization(stats *types.Stats, dimensions []*cloudwatch.Dimension) (user, kernel *cloudwatch.MetricDatum) {
+	u, k := docker.CalculateCpuModeUtilization(stats)
+	return percentDatum(metricNameCPUUser, u, stats, dimensions), percentDatum(metricNameCPUKernel, k, stats, dimensions)
+}
+
+// GetCpuCoreUtilization returns the utilization of every CPU core, each with
+// the dimensions and the index of the core as the dimension named
+// cpuDimension. It returns nothing if the stats have no per-core usage.
+func GetCpuCoreUtilization(stats *types.Stats, dimensions []*cloudwatch.Dimension, cpuDimension string) []*cloudwatch.MetricDatum {
+	var d []*cloudwatch.MetricDatum
+	for i, value := range docker.CalculatePerCpuUtilization(stats) {
+		dims := make([]*cloudwatch.Dimension, len(dimensions), len(dimensions)+1)
+		copy(dims, dimensions)
+		dims = append(dims, Dimension(cpuDimension, strconv.Itoa(i)))
+		d = append(d, percentDatum(metricNameCPUCore, value, stats, dims))
+	}
+	return d
+}
+
+func percentDatum(name string, value float64, stats *types.Stats, dimensions []*cloudwatch.Dimension) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(name),
+		Unit:       aws.String(cloudwatch.StandardUnitPercent),
+		Value:      aws.Float64(value),
+		Timestamp:  timestamp(stats),
+		Dimensions: dimensions,
+	}
+}
+
+// GetEphemeralStorage returns the bytes of ephemeral storage a task uses, and
+// their percentage of the reserved bytes
+func GetEphemeralStorage(used, reserved uint64, dimensions []*cloudwatch.Dimension) (utilized, utilization *cloudwatch.MetricDatum) {
+	now := aws.Time(time.Now())
+	utilized = &cloudwatch.MetricDatum{
+		MetricName: aws.String(metricNameEphemeralStorageUtilized),
+		Unit:       aws.String(cloudwatch.StandardUnitBytes),
+		Value:      aws.Float64(float64(used)),
+		Timestamp:  now,
+		Dimensions: dimensions,
+	}
+	utilization = &cloudwatch.MetricDatum{
+		MetricName: aws.String(metricNameEphemeralStorageUtilization),
+		Unit:       aws.String(cloudwatch.StandardUnitPercent),
+		Value:      aws.Float64(float64(used) / float64(reserved) * 100),
+		Timestamp:  now,
+		Dimensions: dimensions,
+	}
+	return utilized, utilization
+}
+
+// Dimension returns the dimension name=value
+func Dimension(name, value string) *cloudwatch.Dimension {
+	return &cloudwatch.Dimension{
+		Name:  aws.String(name),
+		Value: aws.String(value),
+	}
+}
+
+// GetTaskStopping returns the marker datum published once when the sidecar
+// shuts down, i.e. when the task is stopping
+func GetTaskStopping(dimensions []*cloudwatch.Dimension) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(metricNameTaskStopping),
+		Unit:       aws.String(cloudwatch.StandardUnitCount),
+		Value:      aws.Float64(1),
+		Timestamp:  aws.Time(time.Now()),
+		Dimensions: dimensions,
+	}
+}
+
+// timestamp returns the time the stats were read at, so that data published
+// late, e.g. after being buffered, is still recorded at the right time
+func timestamp(stats *types.Stats) *time.Time {
+	if stats.Read.IsZero() {
+		return aws.Time(time.Now())
+	}
+	return aws.Time(stats.Read)
+}
+
+func PutMetrics(ctx context.Context, client *cloudwatch.CloudWatch, namespace string, input ...*cloudwatch.MetricDatum) error {
+	_, err := client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(namespace),
 		MetricData: input,
 	})
 	return err
@@ -0,0 +1,88 @@
+package cw
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+// Containers of the ec2-windows fixtures
+const (
+	iisContainer     = "4f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	sidecarContainer = "a0b1c2d3e4f5061728394a5b6c7d8e9fa0b1c2d3e4f5061728394a5b6c7d8e9f"
+)
+
+func assertDatum(t *testing.T, what string, d *cloudwatch.MetricDatum, name, unit string, value float64) {
+	t.Helper()
+	if d == nil {
+		t.Errorf("%s: no %s", what, name)
+		return
+	}
+	if aws.StringValue(d.MetricName) != name || aws.StringValue(d.Unit) != unit {
+		t.Errorf("%s: got %s in %s, want %s in %s", what, aws.StringValue(d.MetricName), aws.StringValue(d.Unit), name, unit)
+	}
+	if got := aws.Float64Value(d.Value); math.Abs(got-value) > 1e-6 {
+		t.Errorf("%s: %s = %v, want %v", what, name, got, value)
+	}
+}
+
+func TestWindowsStats(t *testing.T) {
+	fixtures, err := fakeecs.LoadFixtures("../fakeecs/testdata/ec2-windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := fixtures.Stats(0), fixtures.Stats(1)
+
+	cases := []struct {
+		id                        string
+		cpu, user, kernel         float64
+		privateWorkingSet         [2]float64
+		storageRead, storageWrite float64
+	}{
+		{
+			id:  iisContainer,
+			cpu: 25, user: 17.5, kernel: 7.5,
+			privateWorkingSet: [2]float64{412876800, 413925376},
+			// 2 MiB read and 512 KiB written over the 10 seconds in between
+			storageRead: 209715.2, storageWrite: 52428.8,
+		},
+		{
+			id:  sidecarContainer,
+			cpu: 1, user: 0.7, kernel: 0.3,
+			privateWorkingSet: [2]float64{31457280, 32505856},
+			storageRead:       209715.2, storageWrite: 52428.8,
+		},
+	}
+	for _, c := range cases {
+		stats, prev := second[c.id], first[c.id]
+		if stats == nil || prev == nil {
+			t.Fatalf("%s: no stats in the fixtures", c.id)
+		}
+		if f := docker.DetectFlavor(stats); f != docker.FlavorWindows {
+			t.Errorf("%s: DetectFlavor = %v, want windows", c.id, f)
+		}
+
+		// One CPU busy all along is 100%, whatever the number of CPUs
+		cpu, _ := GetCpuUtilization(stats, nil)
+		assertDatum(t, c.id, cpu, "CPUUtilization", "Percent", c.cpu)
+		user, kernel := GetCpuModeUtilization(stats, nil)
+		assertDatum(t, c.id, user, "CPUUserUtilization", "Percent", c.user)
+		assertDatum(t, c.id, kernel, "CPUKernelUtilization", "Percent", c.kernel)
+
+		assertDatum(t, c.id, GetMemoryPrivateWorkingSet(prev, nil), "MemoryPrivateWorkingSet", "Bytes", c.privateWorkingSet[0])
+		assertDatum(t, c.id, GetMemoryPrivateWorkingSet(stats, nil), "MemoryPrivateWorkingSet", "Bytes", c.privateWorkingSet[1])
+
+		read, write := GetStorageThroughput(stats, prev, nil)
+		assertDatum(t, c.id, read, "StorageReadBytes", "Bytes/Second", c.storageRead)
+		assertDatum(t, c.id, write, "StorageWriteBytes", "Bytes/Second", c.storageWrite)
+		// The counters of a restarted container go back to zero
+		if read, write := GetStorageThroughput(prev, stats, nil); read != nil || write != nil {
+			t.Errorf("%s: got a throughput from counters going backwards", c.id)
+		}
+	}
+}
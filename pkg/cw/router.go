@@ -0,0 +1,49 @@
+package cw
+
+import (
+	"sort"
+	"sync"
+)
+
+// Router holds the sinks of every namespace metric data is put to, created on
+// first use
+type Router struct {
+	newSinks func(namespace string) []Sink
+
+	mu    sync.Mutex
+	sinks map[string][]Sink
+}
+
+// NewRouter returns a Router creating the sinks of a namespace with newSinks
+func NewRouter(newSinks func(namespace string) []Sink) *Router {
+	return &Router{newSinks: newSinks, sinks: make(map[string][]Sink)}
+}
+
+// Sinks returns the sinks of namespace
+func (r *Router) Sinks(namespace string) []Sink {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sinks, ok := r.sinks[namespace]
+	if !ok {
+		sinks = r.newSinks(namespace)
+		r.sinks[namespace] = sinks
+	}
+	return sinks
+}
+
+// All returns the sinks created so far, ordered by namespace
+func (r *Router) All() []Sink {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	namespaces := make([]string, 0, len(r.sinks))
+	for ns := range r.sinks {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var all []Sink
+	for _, ns := range namespaces {
+		all = append(all, r.sinks[ns]...)
+	}
+	return all
+}
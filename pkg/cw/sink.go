@@ -0,0 +1,118 @@
+package cw
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
+)
+
+const (
+	// maxDatumsPerRequest is the maximum number of datums PutMetricData accepts
+	// in a single request
+	maxDatumsPerRequest = 20
+)
+
+// Sink is a destination of metric data
+type Sink interface {
+	// Name identifies the sink in logs
+	Name() string
+	// Put sends data, or buffers it to send later
+	Put(ctx context.Context, data ...*cloudwatch.MetricDatum) error
+	// Flush sends everything buffered so far
+	Flush(ctx context.Context) error
+}
+
+// BufferedSink is a Sink sending metric data to CloudWatch in batches. Data
+// which couldn't be sent because of a server error or throttling is kept, up
+// to MaxBuffered datums, and sent along with the next Put or Flush.
+type BufferedSink struct {
+	Client      *cloudwatch.CloudWatch
+	Namespace   string
+	MaxBuffered int
+
+	mu     sync.Mutex
+	buffer []*cloudwatch.MetricDatum
+}
+
+// NewBufferedSink returns a BufferedSink sending data with client to
+// namespace, keeping up to maxBuffered datums while CloudWatch is unavailable
+func NewBufferedSink(client *cloudwatch.CloudWatch, namespace string, maxBuffered int) *BufferedSink {
+	return &BufferedSink{
+		Client:      client,
+		Namespace:   namespace,
+		MaxBuffered: maxBuffered,
+	}
+}
+
+// Name implements Sink
+func (s *BufferedSink) Name() string {
+	return "cloudwatch:" + s.Namespace
+}
+
+// Put implements Sink. It buffers data then flushes the buffer.
+func (s *BufferedSink) Put(ctx context.Context, data ...*cloudwatch.MetricDatum) error {
+	s.mu.Lock()
+	s.buffer = append(s.buffer, data...)
+	// Drop the oldest data first, it's the least useful one
+	if over := len(s.buffer) - s.MaxBuffered; s.MaxBuffered > 0 && over > 0 {
+		s.buffer = s.buffer[over:]
+	}
+	s.mu.Unlock()
+
+	return s.Flush(ctx)
+}
+
+// Flush implements Sink
+func (s *BufferedSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { telemetry.Default.SetBufferedDatums(s.Name(), len(s.buffer)) }()
+
+	for len(s.buffer) > 0 {
+		n := len(s.buffer)
+		if n > maxDatumsPerRequest {
+			n = maxDatumsPerRequest
+		}
+		start := time.Now()
+		err := PutMetrics(ctx, s.Client, s.Namespace, s.buffer[:n]...)
+		telemetry.Default.ObservePublish(publishResult(err), n, time.Since(start))
+		if err != nil {
+			// Sending rejected data again would fail the same way
+			if !IsRetryable(err) {
+				s.buffer = s.buffer[n:]
+			}
+			return err
+		}
+		s.buffer = s.buffer[n:]
+	}
+	return nil
+}
+
+func publishResult(err error) telemetry.PublishResult {
+	if err == nil {
+		return telemetry.PublishSuccess
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.Code() == "Throttling" {
+		return telemetry.PublishThrottled
+	}
+	return telemetry.PublishFailure
+}
+
+// IsRetryable returns false if err is a CloudWatch error which will happen
+// again if the same request is retried, e.g. invalid data or access denied
+func IsRetryable(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return true
+	}
+	if reqErr.Code() == "Throttling" {
+		return true
+	}
+	return reqErr.StatusCode() >= http.StatusInternalServerError
+}
@@ -0,0 +1,179 @@
+// Package daemon reports every task of an EC2 container instance, from a
+// single container run as a DAEMON service, instead of the task the sidecar
+// runs in.
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+const (
+	// pauseContainerName is the name the ECS agent gives the CNI pause
+	// container of awsvpc tasks, whose type the introspection API omits
+	pauseContainerName = "~internal~ecs~pause"
+	// maxConcurrentStats is the maximum number of stats requested from Docker
+	// at once, each taking about a second
+	maxConcurrentStats = 8
+)
+
+// Source is an ecs.MetadataSource covering every task of the container
+// instance. The tasks are listed by the ECS agent introspection API and their
+// containers' stats read from Docker.
+//
+// The container instance stands for the task: TaskMetadata returns a task
+// whose TaskARN is the container instance ARN and whose containers are the
+// ones of every task, each labeled with the identity of its own task the way
+// the ECS agent labels Docker containers, see ecs.TaskIdentity.
+type Source struct {
+	Agent  *ecs.AgentClient
+	Docker *docker.Client
+
+	mu       sync.Mutex
+	metadata *ecs.AgentMetadata
+	task     *ecs.TaskResponse
+}
+
+// NewSource returns a Source listing tasks with agent and reading stats with
+// dockerClient
+func NewSource(agent *ecs.AgentClient, dockerClient *docker.Client) *Source {
+	return &Source{Agent: agent, Docker: dockerClient}
+}
+
+// TaskMetadata implements ecs.MetadataSource. It lists the tasks again on
+// every call.
+func (s *Source) TaskMetadata(ctx context.Context) (*ecs.TaskResponse, error) {
+	metadata, err := s.agentMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.Agent.Tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	task := &ecs.TaskResponse{
+		Cluster:     metadata.Cluster,
+		TaskARN:     metadata.ContainerInstanceArn,
+		KnownStatus: "RUNNING",
+	}
+	for _, t := range tasks {
+		for _, c := range t.Containers {
+			con := ecs.ContainerResponse{
+				ID:         c.DockerID,
+				Name:       c.Name,
+				DockerName: c.DockerName,
+				// The introspection API only reports the status of tasks
+				KnownStatus: t.KnownStatus,
+				Labels: map[string]string{
+					ecs.LabelCluster:                metadata.Cluster,
+					ecs.LabelContainerName:          c.Name,
+					ecs.LabelTaskARN:                t.Arn,
+					ecs.LabelTaskDefinitionFamily:   t.Family,
+					ecs.LabelTaskDefinitionRevision: t.Version,
+				},
+			}
+			if c.Name == pauseContainerName {
+				con.Type = "CNI_PAUSE"
+			}
+			task.Containers = append(task.Containers, con)
+		}
+	}
+
+	s.mu.Lock()
+	s.task = task
+	s.mu.Unlock()
+	return task, nil
+}
+
+// agentMetadata returns the container instance metadata, which doesn't change
+// once the agent has registered the container instance. Until then, the
+// agent reports no container instance ARN and the metadata isn't cached.
+func (s *Source) agentMetadata(ctx context.Context) (*ecs.AgentMetadata, error) {
+	s.mu.Lock()
+	metadata := s.metadata
+	s.mu.Unlock()
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	metadata, err := s.Agent.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.ContainerInstanceArn == "" {
+		return nil, fmt.Errorf("unable to get the container instance ARN: the ECS agent hasn't registered the container instance yet")
+	}
+	s.mu.Lock()
+	s.metadata = metadata
+	s.mu.Unlock()
+	return metadata, nil
+}
+
+// TaskStats implements ecs.MetadataSource. It returns the stats of the
+// running containers of the tasks last listed by TaskMetadata. A container
+// which stopped since is left out.
+func (s *Source) TaskStats(ctx context.Context) (map[string]*types.Stats, error) {
+	stats, err := s.TaskStatsJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ecs.StatsOf(stats), nil
+}
+
+// TaskStatsJSON implements ecs.StatsJSONSource, as TaskStats does
+func (s *Source) TaskStatsJSON(ctx context.Context) (map[string]*types.StatsJSON, error) {
+	s.mu.Lock()
+	task := s.task
+	s.mu.Unlock()
+	if task == nil {
+		var err error
+		if task, err = s.TaskMetadata(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	containers := ecs.RunningContainers(task)
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		stats    = make(map[string]*types.StatsJSON, len(containers))
+		firstErr error
+		slots    = make(chan struct{}, maxConcurrentStats)
+	)
+	for _, con := range containers {
+		wg.Add(1)
+		go func(con ecs.ContainerResponse) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			st, err := s.Docker.ContainerStats(ctx, con.ID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				logger.With(logger.Fields{"container": con.DockerName}).Debugf("unable to get the container stats: %v", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			stats[con.ID] = st
+		}(con)
+	}
+	wg.Wait()
+
+	// Only fail when Docker is unreachable, not when a container just stopped
+	if len(stats) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return stats, nil
+}
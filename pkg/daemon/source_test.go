@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+const instanceARN = "arn:aws:ecs:us-west-2:123456789012:container-instance/fake/1f73d099b914411ca9ff81633b7741dd"
+
+// fakeAgent serves the introspection API of a container instance running the
+// task of a scenario, and a container "gone" which Docker doesn't know about.
+// The agent registers the container instance after registerAfter requests of
+// /v1/metadata.
+type fakeAgent struct {
+	scenario      fakeecs.Scenario
+	registerAfter int
+
+	mu       sync.Mutex
+	metadata int
+}
+
+func (a *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/metadata":
+		a.mu.Lock()
+		metadata := ecs.AgentMetadata{Cluster: "fake", Version: "Amazon ECS Agent - v1.30.0"}
+		if a.metadata >= a.registerAfter {
+			metadata.ContainerInstanceArn = instanceARN
+		}
+		a.metadata++
+		a.mu.Unlock()
+		json.NewEncoder(w).Encode(metadata)
+	case "/v1/tasks":
+		task := a.scenario.Task(0)
+		t := ecs.AgentTask{
+			Arn:           task.TaskARN,
+			DesiredStatus: "RUNNING",
+			KnownStatus:   task.KnownStatus,
+			Family:        task.Family,
+			Version:       task.Revision,
+			Containers:    []ecs.AgentContainer{{DockerID: "gone", DockerName: "ecs-gone-1-gone", Name: "gone"}},
+		}
+		for _, con := range task.Containers {
+			t.Containers = append(t.Containers, ecs.AgentContainer{DockerID: con.ID, DockerName: con.DockerName, Name: con.Name})
+		}
+		json.NewEncoder(w).Encode(map[string][]ecs.AgentTask{"Tasks": {t}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *fakeAgent) metadataRequests() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.metadata
+}
+
+// startSource returns a Source of agent and of the fake Docker Engine API of
+// agent's scenario, and a function stopping them
+func startSource(t *testing.T, agent *fakeAgent) (*Source, func()) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	dockerServer := &http.Server{Handler: fakeecs.NewDockerServer(agent.scenario, time.Second)}
+	go dockerServer.Serve(l)
+	agentServer := httptest.NewServer(agent)
+
+	source := NewSource(ecs.NewAgentClient(agentServer.URL, http.DefaultClient), docker.NewClient(socket))
+	return source, func() {
+		agentServer.Close()
+		dockerServer.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSourceTaskMetadata(t *testing.T) {
+	agent := &fakeAgent{scenario: fakeecs.AWSVPC()}
+	source, stop := startSource(t, agent)
+	defer stop()
+	want := agent.scenario.Task(0)
+
+	for i := 0; i < 2; i++ {
+		task, err := source.TaskMetadata(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.TaskARN != instanceARN || task.Cluster != "fake" || task.KnownStatus != "RUNNING" {
+			t.Errorf("got the task ARN %q, the cluster %q and the status %q", task.TaskARN, task.Cluster, task.KnownStatus)
+		}
+		if len(task.Containers) != len(want.Containers)+1 {
+			t.Fatalf("got %d containers, want %d", len(task.Containers), len(want.Containers)+1)
+		}
+		for _, con := range task.Containers {
+			if con.Labels[ecs.LabelTaskARN] != want.TaskARN || con.Labels[ecs.LabelTaskDefinitionFamily] != "fake-app" ||
+				con.Labels[ecs.LabelTaskDefinitionRevision] != "1" || con.Labels[ecs.LabelContainerName] != con.Name {
+				t.Errorf("%s: got the labels %v", con.Name, con.Labels)
+			}
+			if ecs.IsPauseContainer(con) != (con.Name == pauseContainerName) {
+				t.Errorf("%s: got the type %q", con.Name, con.Type)
+			}
+		}
+	}
+	// The container instance metadata doesn't change
+	if n := agent.metadataRequests(); n != 1 {
+		t.Errorf("got %d requests of the metadata, want 1", n)
+	}
+}
+
+func TestSourceNotRegistered(t *testing.T) {
+	agent := &fakeAgent{scenario: fakeecs.Steady(), registerAfter: 1}
+	source, stop := startSource(t, agent)
+	defer stop()
+	ctx := context.Background()
+
+	if _, err := source.TaskMetadata(ctx); err == nil {
+		t.Fatal("got no error before the container instance is registered")
+	}
+	for i := 0; i < 2; i++ {
+		task, err := source.TaskMetadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.TaskARN != instanceARN {
+			t.Errorf("got the task ARN %q", task.TaskARN)
+		}
+	}
+	if n := agent.metadataRequests(); n != 2 {
+		t.Errorf("got %d requests of the metadata, want 2", n)
+	}
+}
+
+func TestSourceTaskStats(t *testing.T) {
+	agent := &fakeAgent{scenario: fakeecs.AWSVPC()}
+	source, stop := startSource(t, agent)
+	defer stop()
+
+	// TaskStats lists the tasks first if TaskMetadata wasn't called yet
+	stats, err := source.TaskStatsJSON(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The container unknown to Docker is left out
+	if len(stats) != 3 || stats["gone"] != nil {
+		t.Errorf("got the stats of %d containers, want 3", len(stats))
+	}
+	app := stats["app-0"]
+	if app == nil {
+		t.Fatal("no stats for app-0")
+	}
+	if app.Name != "/ecs-fake-app-1-app" || app.ID != "app-0" || app.MemoryStats.Limit == 0 {
+		t.Errorf("got the name %q, the ID %q and the memory stats %+v", app.Name, app.ID, app.MemoryStats)
+	}
+}
+
+func TestSourceDockerUnreachable(t *testing.T) {
+	agent := &fakeAgent{scenario: fakeecs.Steady()}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+	source := NewSource(ecs.NewAgentClient(server.URL, http.DefaultClient), docker.NewClient("/nonexistent/docker.sock"))
+
+	if _, err := source.TaskStats(context.Background()); err == nil {
+		t.Error("got no error while Docker is unreachable")
+	}
+}
@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// DefaultSocket is the path of the Docker Engine API socket
+const DefaultSocket = "/var/run/docker.sock"
+
+// Client is a minimal client of the Docker Engine API over its unix socket
+type Client struct {
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for the Docker Engine API listening on socket
+func NewClient(socket string) *Client {
+	return &Client{
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// ContainerStats returns one sample of the stats of the container id. Docker
+// takes two readings about a second apart, so that the CPU usage can be
+// computed from the sample alone.
+func (c *Client) ContainerStats(ctx context.Context, id string) (*types.StatsJSON, error) {
+	var stats types.StatsJSON
+	if err := c.get(ctx, "/containers/"+url.PathEscape(id)+"/stats?stream=false", &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// StreamStats calls fn with every sample of the stats of the container id
+// Docker streams, about one per second, until ctx is done, the container
+// stops or fn returns false
+func (c *Client) StreamStats(ctx context.Context, id string, fn func(*types.StatsJSON) bool) error {
+	path := "/containers/" + url.PathEscape(id) + "/stats"
+	resp, err := c.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var stats types.StatsJSON
+		if err := dec.Decode(&stats); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("unable to decode the stats of %s: %v", id, err)
+		}
+		if !fn(&stats) {
+			return nil
+		}
+	}
+}
+
+// ContainerList returns the running containers having the label key, or
+// key=value if value isn't empty
+func (c *Client) ContainerList(ctx context.Context, key, value string) ([]types.Container, error) {
+	label := key
+	if value != "" {
+		label += "=" + value
+	}
+	f, err := filters.ToJSON(filters.NewArgs(filters.Arg("label", label)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the filters: %v", err)
+	}
+	var containers []types.Container
+	if err := c.get(ctx, "/containers/json?filters="+url.QueryEscape(f), &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// get decodes the JSON response to path into v
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode the response to %s: %v", path, err)
+	}
+	return nil
+}
+
+// do returns the successful response to a GET of path, whose body must be
+// closed
+func (c *Client) do(ctx context.Context, path string) (*http.Response, error) {
+	// The host is ignored by the transport, but must be valid
+	req, err := http.NewRequest(http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %v", err)
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to request %s: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e types.ErrorResponse
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &e)
+		return nil, fmt.Errorf("unable to get %s: %s: %s", path, resp.Status, e.Message)
+	}
+	return resp, nil
+}
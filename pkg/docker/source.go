@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+// restreamDelay is how long to wait before streaming the stats of a container
+// again after its stream failed
+const restreamDelay = time.Second
+
+// Source is an ecs.MetadataSource reading the containers of a task from
+// Docker rather than from the task metadata endpoint: the containers and their
+// identity come from the labels the ECS agent puts on them, and their stats
+// from one stream per container, so that the latest sample is always at hand.
+//
+// Metadata only provides what Docker doesn't know about: the task ARN, the
+// status, the limits and the tags of the task.
+type Source struct {
+	Client   *Client
+	Metadata ecs.MetadataSource
+
+	mu      sync.Mutex
+	task    *ecs.TaskResponse
+	streams map[string]*stream
+}
+
+type stream struct {
+	cancel context.CancelFunc
+	latest *types.StatsJSON
+}
+
+// NewSource returns a Source reading the containers of the task described by
+// metadata from client
+func NewSource(client *Client, metadata ecs.MetadataSource) *Source {
+	return &Source{Client: client, Metadata: metadata, streams: make(map[string]*stream)}
+}
+
+// TaskMetadata implements ecs.MetadataSource. It lists the containers of the
+// task again on every call, and starts or stops streaming their stats
+// accordingly. Metadata is no longer asked once the task is RUNNING, unless
+// it reports the ephemeral storage usage.
+func (s *Source) TaskMetadata(ctx context.Context) (*ecs.TaskResponse, error) {
+	task, err := s.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if task.TaskARN == "" {
+		return nil, fmt.Errorf("unable to list the containers of the task: no task ARN")
+	}
+	containers, err := s.Client.ContainerList(ctx, ecs.LabelTaskARN, task.TaskARN)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+
+	docker := *task
+	docker.Containers = make([]ecs.ContainerResponse, 0, len(containers))
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		docker.Containers = append(docker.Containers, containerResponse(c))
+		ids = append(ids, c.ID)
+		// The labels are what the containers really run, even if the
+		// endpoint omits them
+		if v := c.Labels[ecs.LabelCluster]; v != "" {
+			docker.Cluster = v
+		}
+		if v := c.Labels[ecs.LabelTaskDefinitionFamily]; v != "" {
+			docker.Family = v
+			docker.Revision = c.Labels[ecs.LabelTaskDefinitionRevision]
+		}
+	}
+	s.track(ids)
+	return &docker, nil
+}
+
+// metadata returns the task metadata, which only changes until the task is
+// RUNNING, except for the ephemeral storage usage: metadata carrying it is
+// asked again every time rather than cached
+func (s *Source) metadata(ctx context.Context) (*ecs.TaskResponse, error) {
+	s.mu.Lock()
+	task := s.task
+	s.mu.Unlock()
+	if task != nil {
+		return task, nil
+	}
+
+	task, err := s.Metadata.TaskMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if task.KnownStatus == "RUNNING" && task.EphemeralStorageMetrics == nil {
+		s.mu.Lock()
+		s.task = task
+		s.mu.Unlock()
+	}
+	return task, nil
+}
+
+// containerResponse returns the metadata of c the task metadata endpoint
+// would return
+func containerResponse(c types.Container) ecs.ContainerResponse {
+	con := ecs.ContainerResponse{
+		ID:          c.ID,
+		Name:        c.Labels[ecs.LabelContainerName],
+		Image:       c.Image,
+		ImageID:     c.ImageID,
+		Labels:      c.Labels,
+		KnownStatus: strings.ToUpper(c.State),
+	}
+	if len(c.Names) > 0 {
+		con.DockerName = strings.TrimPrefix(c.Names[0], "/")
+	}
+	if con.Name == "~internal~ecs~pause" {
+		con.Type = "CNI_PAUSE"
+	}
+	return con
+}
+
+// TaskStats implements ecs.MetadataSource. It returns the latest sample of
+// every container listed by the last TaskMetadata, leaving out the ones
+// without a sample yet.
+func (s *Source) TaskStats(ctx context.Context) (map[string]*types.Stats, error) {
+	stats, err := s.TaskStatsJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ecs.StatsOf(stats), nil
+}
+
+// TaskStatsJSON implements ecs.StatsJSONSource, as TaskStats does
+func (s *Source) TaskStatsJSON(ctx context.Context) (map[string]*types.StatsJSON, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]*types.StatsJSON, len(s.streams))
+	for id, st := range s.streams {
+		if st.latest != nil {
+			stats[id] = st.latest
+		}
+	}
+	return stats, nil
+}
+
+// Close stops every stream
+func (s *Source) Close() {
+	s.track(nil)
+}
+
+// track streams the stats of the containers ids, and stops streaming the
+// others
+func (s *Source) track(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+		if _, ok := s.streams[id]; !ok {
+			ctx, cancel := context.WithCancel(context.Background())
+			st := &stream{cancel: cancel}
+			s.streams[id] = st
+			go s.stream(ctx, id, st)
+		}
+	}
+	for id, st := range s.streams {
+		if !keep[id] {
+			st.cancel()
+			delete(s.streams, id)
+		}
+	}
+}
+
+// stream keeps the latest sample of the container id in st until ctx is done
+func (s *Source) stream(ctx context.Context, id string, st *stream) {
+	for {
+		err := s.Client.StreamStats(ctx, id, func(stats *types.StatsJSON) bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			st.latest = stats
+			return ctx.Err() == nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.With(logger.Fields{"container": id}).Debugf("unable to stream the container stats: %v", err)
+		}
+		t := time.NewTimer(restreamDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
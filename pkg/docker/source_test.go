@@ -0,0 +1,267 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+// sampleInterval is how often the fake Docker server moves to the next sample
+const sampleInterval = 100 * time.Millisecond
+
+// startDockerServer serves the Docker Engine API of scenario on a unix socket
+// and returns a Client of it, and a function stopping the server
+func startDockerServer(t *testing.T, scenario fakeecs.Scenario) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: fakeecs.NewDockerServer(scenario, sampleInterval)}
+	go server.Serve(l)
+	return NewClient(socket), func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeMetadata is an ecs.MetadataSource serving the task of a scenario as it
+// is at the first sample
+type fakeMetadata struct {
+	scenario fakeecs.Scenario
+}
+
+func (m fakeMetadata) TaskMetadata(context.Context) (*ecs.TaskResponse, error) {
+	return m.scenario.Task(0), nil
+}
+
+func (m fakeMetadata) TaskStats(context.Context) (map[string]*types.Stats, error) {
+	return nil, nil
+}
+
+// countingMetadata is an ecs.MetadataSource serving the task of a scenario
+// at the next sample on every call
+type countingMetadata struct {
+	scenario fakeecs.Scenario
+	mu       sync.Mutex
+	calls    int
+}
+
+func (m *countingMetadata) TaskMetadata(context.Context) (*ecs.TaskResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	task := m.scenario.Task(m.calls)
+	m.calls++
+	return task, nil
+}
+
+func (m *countingMetadata) TaskStats(context.Context) (map[string]*types.Stats, error) {
+	return nil, nil
+}
+
+// eventually calls cond every 10ms until it returns true, and fails the test
+// if it doesn't within timeout
+func eventually(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestContainerList(t *testing.T) {
+	scenario := fakeecs.Steady()
+	client, stop := startDockerServer(t, scenario)
+	defer stop()
+
+	task := scenario.Task(0)
+	containers, err := client.ContainerList(context.Background(), ecs.LabelTaskARN, task.TaskARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range containers {
+		names = append(names, c.Labels[ecs.LabelContainerName])
+		if c.Labels[ecs.LabelTaskDefinitionFamily] != task.Family {
+			t.Errorf("%s: got the labels %v", c.ID, c.Labels)
+		}
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "app" || names[1] != "taskmetadata-cloudwatch" {
+		t.Errorf("got the containers %v", names)
+	}
+
+	others, err := client.ContainerList(context.Background(), ecs.LabelTaskARN, "arn:aws:ecs:us-west-2:123456789012:task/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(others) != 0 {
+		t.Errorf("got %d containers of another task", len(others))
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	client, stop := startDockerServer(t, fakeecs.Steady())
+	defer stop()
+
+	var samples []*types.StatsJSON
+	err := client.StreamStats(context.Background(), "app-0", func(stats *types.StatsJSON) bool {
+		samples = append(samples, stats)
+		return len(samples) < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for i, s := range samples {
+		if s.ID != "app-0" {
+			t.Errorf("sample %d: got the stats of %s", i, s.ID)
+		}
+		if i > 0 && !s.Read.After(samples[i-1].Read) {
+			t.Errorf("sample %d: read at %v, not after the previous sample", i, s.Read)
+		}
+	}
+
+	if _, err := client.ContainerStats(context.Background(), "unknown"); err == nil {
+		t.Error("no error for an unknown container")
+	}
+}
+
+func TestSourceFollowsRestarts(t *testing.T) {
+	// The application container restarts with a new ID every 6 samples
+	scenario := fakeecs.Restarting()
+	client, stop := startDockerServer(t, scenario)
+	defer stop()
+	source := NewSource(client, fakeMetadata{scenario})
+	defer source.Close()
+	ctx := context.Background()
+
+	has := func(ids ...string) func() bool {
+		return func() bool {
+			stats, _ := source.TaskStats(ctx)
+			for _, id := range ids {
+				if stats[id] == nil {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	task, err := source.TaskMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Containers) != 2 {
+		t.Fatalf("got %d containers, want 2", len(task.Containers))
+	}
+	eventually(t, 3*sampleInterval, "the stats of app-0", has("app-0", "taskmetadata-cloudwatch-0"))
+
+	// Once restarted, the container is listed with its new ID, whose stats
+	// are streamed, while the stopped one is dropped
+	eventually(t, 9*sampleInterval, "app-1 to be listed", func() bool {
+		task, err := source.TaskMetadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, con := range task.Containers {
+			if con.ID == "app-1" {
+				return true
+			}
+		}
+		return false
+	})
+	eventually(t, 3*sampleInterval, "the stats of app-1", has("app-1", "taskmetadata-cloudwatch-0"))
+	stats, _ := source.TaskStats(ctx)
+	if _, ok := stats["app-0"]; ok {
+		t.Error("the stopped app-0 is still reported")
+	}
+	if len(stats) != 2 {
+		t.Errorf("got the stats of %d containers, want 2", len(stats))
+	}
+}
+
+func TestSourceMetadataCache(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		scenario fakeecs.Scenario
+		calls    int
+	}{
+		{"steady", fakeecs.Steady(), 1},
+		// The ephemeral storage usage keeps changing, so it isn't cached
+		{"fargate", fakeecs.Fargate(), 3},
+	} {
+		client, stop := startDockerServer(t, c.scenario)
+		metadata := &countingMetadata{scenario: c.scenario}
+		source := NewSource(client, metadata)
+
+		var usages []int64
+		for i := 0; i < 3; i++ {
+			task, err := source.TaskMetadata(context.Background())
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if task.EphemeralStorageMetrics != nil {
+				usages = append(usages, task.EphemeralStorageMetrics.Utilized)
+			}
+		}
+		source.Close()
+		stop()
+
+		if metadata.calls != c.calls {
+			t.Errorf("%s: metadata asked %d times, want %d", c.name, metadata.calls, c.calls)
+		}
+		for i := 1; i < len(usages); i++ {
+			if usages[i] <= usages[i-1] {
+				t.Errorf("%s: got the ephemeral storage usages %v", c.name, usages)
+			}
+		}
+	}
+}
+
+func TestSourceTaskStatsJSON(t *testing.T) {
+	scenario := fakeecs.Restarting()
+	client, stop := startDockerServer(t, scenario)
+	defer stop()
+	source := NewSource(client, fakeMetadata{scenario})
+	defer source.Close()
+	ctx := context.Background()
+
+	if _, err := source.TaskMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var st *types.StatsJSON
+	eventually(t, 3*sampleInterval, "the stats of app-0", func() bool {
+		stats, _ := source.TaskStatsJSON(ctx)
+		st = stats["app-0"]
+		return st != nil
+	})
+	if st.Name != "/ecs-fake-app-1-app" || st.ID != "app-0" {
+		t.Errorf("got the name %q and the ID %q", st.Name, st.ID)
+	}
+	if _, ok := st.Networks["eth0"]; !ok {
+		t.Errorf("got the networks %+v", st.Networks)
+	}
+}
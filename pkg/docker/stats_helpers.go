@@ -4,14 +4,92 @@ package docker
 
 import "github.com/docker/docker/api/types"
 
+// Flavor is the shape of the stats, which depends on the OS and, on Linux, on
+// the cgroup version of the host
+type Flavor int
+
+const (
+	// FlavorCgroupV1 stats have `percpu_usage`, and memory.stat keys such as
+	// `cache`, `rss` and their hierarchical `total_*` counterparts
+	FlavorCgroupV1 Flavor = iota
+	// FlavorCgroupV2 stats have no `percpu_usage`, and memory.stat keys such
+	// as `anon` and `file`
+	FlavorCgroupV2
+	// FlavorWindows stats have `num_procs`, `storage_stats` and the commit
+	// and private working set memory, but no system CPU usage, memory limit
+	// or memory.stat. CPU times are in 100's of nanoseconds.
+	FlavorWindows
+)
+
+func (f Flavor) String() string {
+	switch f {
+	case FlavorCgroupV2:
+		return "cgroupv2"
+	case FlavorWindows:
+		return "windows"
+	}
+	return "cgroupv1"
+}
+
+// DetectFlavor returns the shape of stats, from the fields only Windows
+// populates, then from the keys of its memory.stat
+func DetectFlavor(stats *types.Stats) Flavor {
+	if stats.NumProcs > 0 || stats.MemoryStats.PrivateWorkingSet > 0 || stats.MemoryStats.Commit > 0 {
+		return FlavorWindows
+	}
+	m := stats.MemoryStats.Stats
+	if _, ok := m["anon"]; ok {
+		return FlavorCgroupV2
+	}
+	if _, ok := m["file"]; ok {
+		return FlavorCgroupV2
+	}
+	return FlavorCgroupV1
+}
+
+// CalculateMemUsageNoCache returns the memory used by the container, without
+// the inactive page cache the kernel can reclaim, as `docker stats` does. On
+// Windows, it is the private working set.
+func CalculateMemUsageNoCache(stats *types.Stats) uint64 {
+	mem := stats.MemoryStats
+	inactive := "inactive_file"
+	switch DetectFlavor(stats) {
+	case FlavorWindows:
+		return mem.PrivateWorkingSet
+	case FlavorCgroupV1:
+		// The hierarchical value, which inactive_file stands for when absent
+		if _, ok := mem.Stats["total_inactive_file"]; ok {
+			inactive = "total_inactive_file"
+		}
+	}
+	if v, ok := mem.Stats[inactive]; ok && v < mem.Usage {
+		return mem.Usage - v
+	}
+	return mem.Usage
+}
+
 func CalculateMemUtilization(stats *types.Stats) float64 {
 	if stats.MemoryStats.Limit != 0 {
-		return float64(stats.MemoryStats.Usage) / float64(stats.MemoryStats.Limit) * 100.0
+		return float64(CalculateMemUsageNoCache(stats)) / float64(stats.MemoryStats.Limit) * 100.0
 	}
 	return 0.0
 }
 
+// onlineCPUs returns the number of CPUs of the host. percpu_usage, which
+// cgroup v2 doesn't have, is only a fallback for older Docker versions.
+func onlineCPUs(stats *types.Stats) float64 {
+	for _, cpu := range []types.CPUStats{stats.CPUStats, stats.PreCPUStats} {
+		if cpu.OnlineCPUs != 0 {
+			return float64(cpu.OnlineCPUs)
+		}
+	}
+	return float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+}
+
 func CalculateCpuUtilization(stats *types.Stats) float64 {
+	if DetectFlavor(stats) == FlavorWindows {
+		return windowsCpuPercent(stats.CPUStats.CPUUsage.TotalUsage, stats.PreCPUStats.CPUUsage.TotalUsage, stats)
+	}
 	var (
 		prevCPU    = stats.PreCPUStats.CPUUsage.TotalUsage
 		prevSystem = stats.PreCPUStats.SystemUsage
@@ -21,14 +99,83 @@ func CalculateCpuUtilization(stats *types.Stats) float64 {
 		cpuDelta = float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(prevCPU)
 		// calculate the change for the entire system between readings
 		systemDelta = float64(stats.CPUStats.SystemUsage) - float64(prevSystem)
-		onlineCPUs  = float64(stats.CPUStats.OnlineCPUs)
+		onlineCPUs  = onlineCPUs(stats)
 	)
 
-	if onlineCPUs == 0.0 {
-		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
-	}
 	if systemDelta > 0.0 && cpuDelta > 0.0 {
 		cpuPercent = (cpuDelta / systemDelta) * onlineCPUs * 100.0
 	}
 	return cpuPercent
 }
+
+// CalculateCpuModeUtilization returns the CPU utilization of the container in
+// user mode and in kernel mode, on the same scale as CalculateCpuUtilization
+func CalculateCpuModeUtilization(stats *types.Stats) (user, kernel float64) {
+	cur, prev := stats.CPUStats.CPUUsage, stats.PreCPUStats.CPUUsage
+	if DetectFlavor(stats) == FlavorWindows {
+		return windowsCpuPercent(cur.UsageInUsermode, prev.UsageInUsermode, stats),
+			windowsCpuPercent(cur.UsageInKernelmode, prev.UsageInKernelmode, stats)
+	}
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if systemDelta <= 0.0 {
+		return 0.0, 0.0
+	}
+	percent := func(cur, prev uint64) float64 {
+		if cur <= prev {
+			return 0.0
+		}
+		return float64(cur-prev) / systemDelta * onlineCPUs(stats) * 100.0
+	}
+	return percent(cur.UsageInUsermode, prev.UsageInUsermode), percent(cur.UsageInKernelmode, prev.UsageInKernelmode)
+}
+
+// CalculatePerCpuUtilization returns the utilization of every CPU core by the
+// container, from 0 to 100 each, or nil if the stats have no per-core usage,
+// as on cgroup v2 hosts
+func CalculatePerCpuUtilization(stats *types.Stats) []float64 {
+	cur, prev := stats.CPUStats.CPUUsage.PercpuUsage, stats.PreCPUStats.CPUUsage.PercpuUsage
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if len(cur) == 0 || len(cur) != len(prev) || systemDelta <= 0.0 {
+		return nil
+	}
+	// The system usage is the sum of every core's
+	coreDelta := systemDelta / onlineCPUs(stats)
+	percents := make([]float64, len(cur))
+	for i := range cur {
+		if cur[i] > prev[i] {
+			percents[i] = float64(cur[i]-prev[i]) / coreDelta * 100.0
+		}
+	}
+	return percents
+}
+
+// windowsCpuPercent returns the utilization of a CPU time going from prev to
+// cur, in 100's of nanoseconds, between the two readings of stats. Windows
+// has no system CPU usage, the time elapsed stands for it. As on Linux, 100%
+// is one CPU busy.
+func windowsCpuPercent(cur, prev uint64, stats *types.Stats) float64 {
+	elapsed := stats.Read.Sub(stats.PreRead)
+	if stats.PreRead.IsZero() || elapsed <= 0 || cur <= prev {
+		return 0.0
+	}
+	return float64(cur-prev) * 100.0 / float64(elapsed.Nanoseconds()) * 100.0
+}
+
+// CalculateStorageBytes returns the bytes the container read from and wrote
+// to its storage since it started, from the storage stats on Windows and the
+// block I/O stats on Linux
+func CalculateStorageBytes(stats *types.Stats) (read, write uint64) {
+	if DetectFlavor(stats) == FlavorWindows {
+		return stats.StorageStats.ReadSizeBytes, stats.StorageStats.WriteSizeBytes
+	}
+	// cgroup v1 capitalizes the operations, cgroup v2 doesn't
+	for _, e := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch e.Op {
+		case "Read", "read":
+			read += e.Value
+		case "Write", "write":
+			write += e.Value
+		}
+	}
+	return read, write
+}
@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// calculatorCase is a case of testdata/calculators.json: stats recorded on a
+// host, along with what the calculators must compute from them
+type calculatorCase struct {
+	Name              string      `json:"name"`
+	Flavor            string      `json:"flavor"`
+	OnlineCPUs        float64     `json:"onlineCPUs"`
+	Stats             types.Stats `json:"stats"`
+	CPUUtilization    float64     `json:"cpuUtilization"`
+	MemoryUsage       uint64      `json:"memoryUsage"`
+	MemoryUtilization float64     `json:"memoryUtilization"`
+}
+
+func loadCalculatorCases(t *testing.T) []calculatorCase {
+	b, err := ioutil.ReadFile("testdata/calculators.json")
+	if err != nil {
+		t.Fatalf("unable to read the cases: %v", err)
+	}
+	var cases []calculatorCase
+	if err := json.Unmarshal(b, &cases); err != nil {
+		t.Fatalf("unable to parse the cases: %v", err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no cases")
+	}
+	return cases
+}
+
+// almostEqual tolerates the rounding of the expected values
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-4
+}
+
+func TestCalculators(t *testing.T) {
+	for _, c := range loadCalculatorCases(t) {
+		stats := c.Stats
+		if got := DetectFlavor(&stats).String(); got != c.Flavor {
+			t.Errorf("%s: DetectFlavor = %s, want %s", c.Name, got, c.Flavor)
+		}
+		if got := onlineCPUs(&stats); got != c.OnlineCPUs {
+			t.Errorf("%s: onlineCPUs = %v, want %v", c.Name, got, c.OnlineCPUs)
+		}
+		if got := CalculateCpuUtilization(&stats); !almostEqual(got, c.CPUUtilization) {
+			t.Errorf("%s: CalculateCpuUtilization = %v, want %v", c.Name, got, c.CPUUtilization)
+		}
+		if got := CalculateMemUsageNoCache(&stats); got != c.MemoryUsage {
+			t.Errorf("%s: CalculateMemUsageNoCache = %v, want %v", c.Name, got, c.MemoryUsage)
+		}
+		if got := CalculateMemUtilization(&stats); !almostEqual(got, c.MemoryUtilization) {
+			t.Errorf("%s: CalculateMemUtilization = %v, want %v", c.Name, got, c.MemoryUtilization)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// DefaultAgentURL is the base URL of the ECS agent introspection API on EC2
+// container instances, reachable from containers in the host network mode
+const DefaultAgentURL = "http://localhost:51678"
+
+// AgentMetadata is the response of the introspection API's `/v1/metadata`
+type AgentMetadata struct {
+	Cluster              string `json:"Cluster"`
+	ContainerInstanceArn string `json:"ContainerInstanceArn"`
+	Version              string `json:"Version"`
+}
+
+// AgentTask is a task of the introspection API's `/v1/tasks`
+type AgentTask struct {
+	Arn           string           `json:"Arn"`
+	DesiredStatus string           `json:"DesiredStatus"`
+	KnownStatus   string           `json:"KnownStatus"`
+	Family        string           `json:"Family"`
+	Version       string           `json:"Version"`
+	Containers    []AgentContainer `json:"Containers"`
+}
+
+// AgentContainer is a container of an AgentTask
+type AgentContainer struct {
+	DockerID   string `json:"DockerId"`
+	DockerName string `json:"DockerName"`
+	Name       string `json:"Name"`
+}
+
+// agentTasksResponse is the response of `/v1/tasks`
+type agentTasksResponse struct {
+	Tasks []AgentTask `json:"Tasks"`
+}
+
+// AgentClient is a client of the ECS agent introspection API, sharing the
+// retries of Client
+type AgentClient struct {
+	client *Client
+}
+
+// NewAgentClient returns an AgentClient for the introspection API at baseURL,
+// using the DefaultRetryPolicy
+func NewAgentClient(baseURL string, httpClient *http.Client) *AgentClient {
+	return &AgentClient{client: NewClient(baseURL, httpClient)}
+}
+
+// Metadata returns the cluster and the ARN of the container instance
+func (a *AgentClient) Metadata(ctx context.Context) (*AgentMetadata, error) {
+	var metadata AgentMetadata
+	if err := a.get(ctx, "/v1/metadata", &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Tasks returns every task the agent manages on the container instance
+func (a *AgentClient) Tasks(ctx context.Context) ([]AgentTask, error) {
+	var tasks agentTasksResponse
+	if err := a.get(ctx, "/v1/tasks", &tasks); err != nil {
+		return nil, err
+	}
+	return tasks.Tasks, nil
+}
+
+func (a *AgentClient) get(ctx context.Context, path string, v interface{}) error {
+	endpoint := a.client.BaseURL + path
+	body, err := a.client.metadataResponse(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &DecodeError{Endpoint: endpoint, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package ecs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	agentMetadataJSON = `{"Cluster":"default","ContainerInstanceArn":"arn:aws:ecs:us-west-2:012345678910:container-instance/default/1f73d099b914411ca9ff81633b7741dd","Version":"Amazon ECS Agent - v1.30.0 (02ff320c)"}`
+	agentTasksJSON    = `{"Tasks":[{"Arn":"arn:aws:ecs:us-west-2:012345678910:task/default/9781c248072a4e0e9e3bf2e2e4aeb2ad","DesiredStatus":"RUNNING","KnownStatus":"RUNNING","Family":"nginx","Version":"3","Containers":[{"DockerId":"9581a69a761a","DockerName":"ecs-nginx-3-nginx-ccccb9eb98c7d1a3a301","Name":"nginx"}]}]}`
+)
+
+// agentHandler serves the introspection API, failing the first requests
+// with the given statuses
+type agentHandler struct {
+	statuses []int
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (h *agentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	n := h.requests
+	h.requests++
+	h.mu.Unlock()
+	if n < len(h.statuses) {
+		w.WriteHeader(h.statuses[n])
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/metadata":
+		w.Write([]byte(agentMetadataJSON))
+	case "/v1/tasks":
+		w.Write([]byte(agentTasksJSON))
+	default:
+		w.Write([]byte("not json"))
+	}
+}
+
+func newTestAgentClient(url string) *AgentClient {
+	a := NewAgentClient(url, http.DefaultClient)
+	a.client.Retry = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	return a
+}
+
+func TestAgentClient(t *testing.T) {
+	server := httptest.NewServer(&agentHandler{})
+	defer server.Close()
+	a := newTestAgentClient(server.URL)
+
+	metadata, err := a.Metadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMetadata := &AgentMetadata{
+		Cluster:              "default",
+		ContainerInstanceArn: "arn:aws:ecs:us-west-2:012345678910:container-instance/default/1f73d099b914411ca9ff81633b7741dd",
+		Version:              "Amazon ECS Agent - v1.30.0 (02ff320c)",
+	}
+	if !reflect.DeepEqual(metadata, wantMetadata) {
+		t.Errorf("got the metadata %+v, want %+v", metadata, wantMetadata)
+	}
+
+	tasks, err := a.Tasks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTasks := []AgentTask{{
+		Arn:           "arn:aws:ecs:us-west-2:012345678910:task/default/9781c248072a4e0e9e3bf2e2e4aeb2ad",
+		DesiredStatus: "RUNNING",
+		KnownStatus:   "RUNNING",
+		Family:        "nginx",
+		Version:       "3",
+		Containers:    []AgentContainer{{DockerID: "9581a69a761a", DockerName: "ecs-nginx-3-nginx-ccccb9eb98c7d1a3a301", Name: "nginx"}},
+	}}
+	if !reflect.DeepEqual(tasks, wantTasks) {
+		t.Errorf("got the tasks %+v, want %+v", tasks, wantTasks)
+	}
+}
+
+func TestAgentClientErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		requests int
+	}{
+		{name: "agent restarting", statuses: []int{503, 500}, requests: 3},
+		{name: "not found", statuses: []int{404}, wantErr: true, requests: 1},
+		{name: "agent down", statuses: []int{503, 503, 503}, wantErr: true, requests: 3},
+	}
+	for _, c := range cases {
+		handler := &agentHandler{statuses: c.statuses}
+		server := httptest.NewServer(handler)
+		_, err := newTestAgentClient(server.URL).Metadata(context.Background())
+		server.Close()
+		if c.wantErr != (err != nil) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+		if handler.requests != c.requests {
+			t.Errorf("%s: got %d requests, want %d", c.name, handler.requests, c.requests)
+		}
+	}
+
+	server := httptest.NewServer(&agentHandler{})
+	defer server.Close()
+	a := newTestAgentClient(server.URL)
+	var v struct{}
+	if err := a.get(context.Background(), "/v1/unknown", &v); err == nil {
+		t.Error("got no error for a body which isn't JSON")
+	} else if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("got %v, want a *DecodeError", err)
+	}
+}
+
+func TestRegion(t *testing.T) {
+	cases := []struct {
+		arn, want string
+	}{
+		{"arn:aws:ecs:us-west-2:012345678910:task/default/9781c248072a4e0e9e3bf2e2e4aeb2ad", "us-west-2"},
+		{"arn:aws:ecs:eu-west-1:012345678910:container-instance/default/1f73d099", "eu-west-1"},
+		{"arn:aws-cn:ecs:cn-north-1:012345678910:task/9781c248", "cn-north-1"},
+		{"", ""},
+		{"9781c248072a4e0e9e3bf2e2e4aeb2ad", ""},
+		{"arn:aws:ecs:us-west-2", ""},
+		{"urn:aws:ecs:us-west-2:012345678910:task/x", ""},
+	}
+	for _, c := range cases {
+		if got := Region(c.arn); got != c.want {
+			t.Errorf("Region(%q): got %q, want %q", c.arn, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
+)
+
+const (
+	// ContainerMetadataEnvVar is the environment variable ECS injects into
+	// containers with the base URL of the Task Metadata Endpoint v3
+	ContainerMetadataEnvVar = "ECS_CONTAINER_METADATA_URI"
+	// ContainerMetadataV4EnvVar is the environment variable ECS injects into
+	// containers with the base URL of the Task Metadata Endpoint v4, on
+	// Fargate platform 1.4.0 and later and ECS agent 1.39.0 and later
+	ContainerMetadataV4EnvVar = "ECS_CONTAINER_METADATA_URI_V4"
+)
+
+// MetadataSource provides the task metadata and the task's container stats.
+// Client implements it against the Task Metadata Endpoint v3.
+type MetadataSource interface {
+	TaskMetadata(ctx context.Context) (*TaskResponse, error)
+	TaskStats(ctx context.Context) (map[string]*types.Stats, error)
+}
+
+// StatsJSONSource is a MetadataSource which also provides the whole stats of
+// the containers, with their name, ID and network usage
+type StatsJSONSource interface {
+	MetadataSource
+	TaskStatsJSON(ctx context.Context) (map[string]*types.StatsJSON, error)
+}
+
+// StatsOf returns the stats part of every container of stats. A container
+// without stats stays nil.
+func StatsOf(stats map[string]*types.StatsJSON) map[string]*types.Stats {
+	s := make(map[string]*types.Stats, len(stats))
+	for id, st := range stats {
+		if st == nil {
+			s[id] = nil
+			continue
+		}
+		s[id] = &st.Stats
+	}
+	return s
+}
+
+// RetryPolicy defines how many times, and how often, a failed request to the
+// metadata endpoint is retried. The delay before the n-th retry is drawn from
+// [d/2, d] where d is BaseDelay doubled n times and capped at MaxDelay.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by NewClient
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 4,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+}
+
+// Backoff returns the delay before the given retry, counting from 0
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Client is a client of the Task Metadata Endpoint v3 or v4
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+	// Version is the version of the endpoint at BaseURL, 3 or 4
+	Version int
+	// WithTags gets the task and container instance tags along with the task
+	// metadata. It requires the v4 endpoint.
+	WithTags bool
+
+	mu sync.Mutex
+	// tags are the ones got from the first /taskWithTags response, nil until
+	// then. Each of these requests has the agent call ListTagsForResource.
+	tags *TaskResponse
+}
+
+// NewClient returns a Client for the metadata endpoint at baseURL, using the
+// DefaultRetryPolicy
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: httpClient,
+		Retry:      DefaultRetryPolicy,
+		Version:    3,
+	}
+}
+
+// NewClientFromEnv returns a Client for the metadata endpoint ECS advertises
+// through the ECS_CONTAINER_METADATA_URI_V4 environment variable, or the
+// ECS_CONTAINER_METADATA_URI one when v4 isn't available. The responses of
+// v4 are a superset of the v3 ones.
+func NewClientFromEnv(httpClient *http.Client) (*Client, error) {
+	if baseURL := os.Getenv(ContainerMetadataV4EnvVar); baseURL != "" {
+		c := NewClient(baseURL, httpClient)
+		c.Version = 4
+		return c, nil
+	}
+	baseURL := os.Getenv(ContainerMetadataEnvVar)
+	if baseURL == "" {
+		return nil, fmt.Errorf("neither %s nor %s is set, the task metadata endpoint is not available", ContainerMetadataV4EnvVar, ContainerMetadataEnvVar)
+	}
+	return NewClient(baseURL, httpClient), nil
+}
+
+// TaskMetadata returns the ECS task's metadata by making the api call to
+// the Task Metadata endpoint. If WithTags is set, the tags are got along with
+// the first response, then reused.
+func (c *Client) TaskMetadata(ctx context.Context) (*TaskResponse, error) {
+	c.mu.Lock()
+	tags := c.tags
+	c.mu.Unlock()
+
+	endpoint := c.BaseURL + "/task"
+	if c.WithTags && tags == nil {
+		endpoint = c.BaseURL + "/taskWithTags"
+	}
+	body, err := c.metadataResponse(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var taskMetadata TaskResponse
+	if err = json.Unmarshal(body, &taskMetadata); err != nil {
+		return nil, &DecodeError{Endpoint: endpoint, Err: err}
+	}
+
+	switch {
+	case !c.WithTags:
+	case tags == nil:
+		c.mu.Lock()
+		c.tags = &TaskResponse{TaskTags: taskMetadata.TaskTags, ContainerInstanceTags: taskMetadata.ContainerInstanceTags}
+		c.mu.Unlock()
+	default:
+		taskMetadata.TaskTags, taskMetadata.ContainerInstanceTags = tags.TaskTags, tags.ContainerInstanceTags
+	}
+	return &taskMetadata, nil
+}
+
+// TaskStats returns stats of the ECS task's containers by making the api
+// call to the Task Metadata endpoint
+func (c *Client) TaskStats(ctx context.Context) (map[string]*types.Stats, error) {
+	taskStats, err := c.TaskStatsJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return StatsOf(taskStats), nil
+}
+
+// TaskStatsJSON implements StatsJSONSource. The network usage is only
+// reported by the endpoint v4.
+func (c *Client) TaskStatsJSON(ctx context.Context) (map[string]*types.StatsJSON, error) {
+	body, err := c.metadataResponse(ctx, c.BaseURL+"/task/stats")
+	if err != nil {
+		return nil, err
+	}
+
+	var taskStats map[string]*types.StatsJSON
+	err = json.Unmarshal(body, &taskStats)
+	if err != nil {
+		return nil, &DecodeError{Endpoint: c.BaseURL + "/task/stats", Err: err}
+	}
+
+	return taskStats, nil
+}
+
+// metadataResponse gets the response body from endpoint, retrying retryable
+// errors according to the client's RetryPolicy. It returns ctx.Err() as soon
+// as ctx is done, including while waiting between retries.
+func (c *Client) metadataResponse(ctx context.Context, endpoint string) ([]byte, error) {
+	for retry := 0; ; retry++ {
+		resp, err := c.metadataResponseOnce(ctx, endpoint)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		telemetry.Default.IncMetadataErrors()
+		if !IsRetryable(err) || retry >= c.Retry.MaxRetries {
+			return nil, err
+		}
+		delay := c.Retry.Backoff(retry)
+		logger.With(logger.Fields{
+			"endpoint": endpoint,
+			"attempt":  fmt.Sprintf("%d/%d", retry+1, c.Retry.MaxRetries+1),
+			"retryIn":  delay.String(),
+		}).Warnf("unable to get metadata response, retrying: %v", err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (c *Client) metadataResponseOnce(ctx context.Context, endpoint string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %v", err)
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &RequestError{Endpoint: endpoint, Err: err}
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Endpoint: endpoint, StatusCode: resp.StatusCode}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &RequestError{Endpoint: endpoint, Err: err}
+	}
+
+	return body, nil
+}
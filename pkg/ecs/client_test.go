@@ -0,0 +1,192 @@
+package ecs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+// countingHandler counts the requests of every path served by handler
+type countingHandler struct {
+	handler http.Handler
+
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	if h.paths == nil {
+		h.paths = make(map[string]int)
+	}
+	h.paths[r.URL.Path[strings.LastIndex(r.URL.Path, "/"):]]++
+	h.mu.Unlock()
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *countingHandler) count(path string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paths[path]
+}
+
+func TestTaskMetadataTagsOnce(t *testing.T) {
+	handler := &countingHandler{handler: fakeecs.NewServer(fakeecs.Steady())}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+	client.Version = 4
+	client.WithTags = true
+
+	for i := 0; i < 3; i++ {
+		task, err := client.TaskMetadata(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.TaskTags["team"] != "payments" {
+			t.Errorf("call %d: got the task tags %v", i, task.TaskTags)
+		}
+	}
+	// ListTagsForResource is only called for the first one
+	if handler.count("/taskWithTags") != 1 || handler.count("/task") != 2 {
+		t.Errorf("got the requests %v", handler.paths)
+	}
+}
+
+// scriptedHandler fails the first requests with the given statuses, 0 being
+// a body which isn't JSON, and serves the others with handler
+type scriptedHandler struct {
+	handler  http.Handler
+	statuses []int
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (h *scriptedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	n := h.requests
+	h.requests++
+	h.mu.Unlock()
+	switch {
+	case n >= len(h.statuses):
+		h.handler.ServeHTTP(w, r)
+	case h.statuses[n] == 0:
+		w.Write([]byte("not json"))
+	default:
+		w.WriteHeader(h.statuses[n])
+	}
+}
+
+func (h *scriptedHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests
+}
+
+func TestTaskMetadataRetries(t *testing.T) {
+	policy := ecs.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	cases := []struct {
+		name      string
+		statuses  []int
+		wantErr   bool
+		retryable bool
+		requests  int
+	}{
+		{name: "ok", requests: 1},
+		{name: "server errors", statuses: []int{500, 503}, requests: 3},
+		{name: "throttling", statuses: []int{429}, requests: 2},
+		{name: "too many failures", statuses: []int{502, 502, 502}, wantErr: true, retryable: true, requests: 3},
+		{name: "not found", statuses: []int{404}, wantErr: true, requests: 1},
+		{name: "forbidden after a server error", statuses: []int{500, 403}, wantErr: true, requests: 2},
+		{name: "decode error", statuses: []int{0}, wantErr: true, requests: 1},
+	}
+	for _, c := range cases {
+		handler := &scriptedHandler{handler: fakeecs.NewServer(fakeecs.Steady()), statuses: c.statuses}
+		server := httptest.NewServer(handler)
+		client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+		client.Version = 4
+		client.Retry = policy
+
+		_, err := client.TaskMetadata(context.Background())
+		server.Close()
+		switch {
+		case c.wantErr && err == nil:
+			t.Errorf("%s: got no error", c.name)
+		case !c.wantErr && err != nil:
+			t.Errorf("%s: got %v", c.name, err)
+		case err != nil && ecs.IsRetryable(err) != c.retryable:
+			t.Errorf("%s: got the error %v, retryable %v", c.name, err, ecs.IsRetryable(err))
+		}
+		if got := handler.count(); got != c.requests {
+			t.Errorf("%s: got %d requests, want %d", c.name, got, c.requests)
+		}
+	}
+}
+
+func TestTaskMetadataDecodeError(t *testing.T) {
+	handler := &scriptedHandler{statuses: []int{0}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+	client.Version = 4
+
+	_, err := client.TaskMetadata(context.Background())
+	if _, ok := err.(*ecs.DecodeError); !ok {
+		t.Errorf("got %v, want a *ecs.DecodeError", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := ecs.RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	cases := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{40, time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			if d := policy.Backoff(c.retry); d < c.max/2 || d > c.max {
+				t.Fatalf("Backoff(%d): got %v, want it within [%v, %v]", c.retry, d, c.max/2, c.max)
+			}
+		}
+	}
+	if d := (ecs.RetryPolicy{}).Backoff(2); d != 0 {
+		t.Errorf("got %v without delays, want 0", d)
+	}
+}
+
+func TestTaskMetadataCancelledWhileWaiting(t *testing.T) {
+	handler := &scriptedHandler{statuses: []int{500, 500}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+	client.Version = 4
+	client.Retry = ecs.RetryPolicy{MaxRetries: 1, BaseDelay: time.Minute, MaxDelay: time.Minute}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.TaskMetadata(ctx)
+	if err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %v", elapsed)
+	}
+	if got := handler.count(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
@@ -0,0 +1,60 @@
+package ecs
+
+import "fmt"
+
+// RequestError is returned when no response could be read from the metadata
+// endpoint, e.g. the connection was refused or timed out. It is retryable.
+type RequestError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("unable to get response from '%s': %v", e.Endpoint, e.Err)
+}
+
+// Retryable reports whether the failed request may succeed when retried
+func (e *RequestError) Retryable() bool {
+	return true
+}
+
+// StatusError is returned when the metadata endpoint responds with a status
+// code other than 200 OK. Only server errors and throttling are retryable.
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("incorrect status code %d from '%s'", e.StatusCode, e.Endpoint)
+}
+
+// Retryable reports whether the failed request may succeed when retried
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == 429
+}
+
+// DecodeError is returned when the response body of the metadata endpoint
+// can't be parsed. It is not retryable.
+type DecodeError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("unable to parse response body from '%s': %v", e.Endpoint, e.Err)
+}
+
+// Retryable reports whether the failed request may succeed when retried
+func (e *DecodeError) Retryable() bool {
+	return false
+}
+
+// IsRetryable returns true if err is one of the errors returned by Client and
+// the request which caused it may succeed when retried
+func IsRetryable(err error) bool {
+	r, ok := err.(interface {
+		Retryable() bool
+	})
+	return ok && r.Retryable()
+}
@@ -0,0 +1,82 @@
+package ecs
+
+import (
+	"strings"
+)
+
+// Docker labels the ECS agent puts on the containers of a task
+const (
+	LabelCluster                = "com.amazonaws.ecs.cluster"
+	LabelContainerName          = "com.amazonaws.ecs.container-name"
+	LabelTaskARN                = "com.amazonaws.ecs.task-arn"
+	LabelTaskDefinitionFamily   = "com.amazonaws.ecs.task-definition-family"
+	LabelTaskDefinitionRevision = "com.amazonaws.ecs.task-definition-version"
+)
+
+// Identity identifies a task in terms of the ECS console. Any field may be
+// empty when it can't be told.
+type Identity struct {
+	// ServiceName is the name of the service which started the task
+	ServiceName string
+	// Family and Revision identify the task definition
+	Family   string
+	Revision string
+	// TaskID is the last part of the task ARN
+	TaskID string
+}
+
+// TaskDefinition returns the task definition as "family:revision", or "" if
+// either is unknown
+func (id Identity) TaskDefinition() string {
+	if id.Family == "" || id.Revision == "" {
+		return ""
+	}
+	return id.Family + ":" + id.Revision
+}
+
+// TaskIdentity returns the identity of task, from its metadata or else the
+// labels the ECS agent put on con
+func TaskIdentity(task *TaskResponse, con ContainerResponse) Identity {
+	id := Identity{
+		ServiceName: task.ServiceName,
+		Family:      task.Family,
+		Revision:    task.Revision,
+		TaskID:      TaskID(task.TaskARN),
+	}
+	if id.ServiceName == "" && strings.HasPrefix(task.Group, "service:") {
+		id.ServiceName = strings.TrimPrefix(task.Group, "service:")
+	}
+	if id.Family == "" {
+		id.Family = con.Labels[LabelTaskDefinitionFamily]
+	}
+	if id.Revision == "" {
+		id.Revision = con.Labels[LabelTaskDefinitionRevision]
+	}
+	if id.TaskID == "" {
+		id.TaskID = TaskID(con.Labels[LabelTaskARN])
+	}
+	return id
+}
+
+// TaskID returns the ID of the task with the given ARN, in either the
+// arn:aws:ecs:region:account:task/id or the
+// arn:aws:ecs:region:account:task/cluster/id format, or "" if arn isn't a
+// task ARN
+func TaskID(arn string) string {
+	i := strings.Index(arn, ":task/")
+	if i < 0 {
+		return ""
+	}
+	resource := arn[i+len(":task/"):]
+	return resource[strings.LastIndex(resource, "/")+1:]
+}
+
+// Region returns the region of the resource with the given ARN, e.g.
+// arn:aws:ecs:region:account:task/id, or "" if arn isn't an ARN
+func Region(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
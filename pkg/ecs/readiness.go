@@ -0,0 +1,169 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+// ReadinessState is the state of the task as seen by Readiness
+type ReadinessState string
+
+const (
+	// StateWaitingForMetadata means no task metadata has been received yet
+	StateWaitingForMetadata ReadinessState = "WaitingForMetadata"
+	// StateWaitingForTask means the task metadata has been received but the
+	// task is not RUNNING yet. Some of its containers may already be running.
+	StateWaitingForTask ReadinessState = "WaitingForTask"
+	// StateRunning means the task is RUNNING
+	StateRunning ReadinessState = "Running"
+	// StateTimedOut means the task didn't become RUNNING within the maximum wait
+	StateTimedOut ReadinessState = "TimedOut"
+)
+
+const taskStatusRunning = "RUNNING"
+
+// ReadinessStatus is a snapshot of Readiness, suitable for JSON encoding
+type ReadinessStatus struct {
+	State     ReadinessState `json:"state"`
+	Since     time.Time      `json:"since"`
+	LastError string         `json:"lastError,omitempty"`
+}
+
+// Readiness polls the task metadata until the task is RUNNING, tolerating
+// transient failures of the metadata endpoint in the meantime
+type Readiness struct {
+	Source       MetadataSource
+	MaxWait      time.Duration
+	PollInterval time.Duration
+
+	mu       sync.RWMutex
+	state    ReadinessState
+	since    time.Time
+	task     *TaskResponse
+	lastErr  error
+	received chan struct{}
+}
+
+// NewReadiness returns a Readiness polling source every second for up to
+// maxWait. A maxWait of 0 waits forever.
+func NewReadiness(source MetadataSource, maxWait time.Duration) *Readiness {
+	return &Readiness{
+		Source:       source,
+		MaxWait:      maxWait,
+		PollInterval: time.Second,
+		state:        StateWaitingForMetadata,
+		since:        time.Now(),
+		received:     make(chan struct{}),
+	}
+}
+
+// Run polls the task metadata until the task is RUNNING and returns nil. It
+// returns an error if MaxWait elapses first, or ctx.Err() if ctx is done.
+func (r *Readiness) Run(ctx context.Context) error {
+	parent := ctx
+	if r.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.MaxWait)
+		defer cancel()
+	}
+
+	for {
+		task, err := r.Source.TaskMetadata(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Warnf("unable to get task metadata, will retry: %v", err)
+		}
+		r.update(task, err)
+		if r.State() == StateRunning {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			// a deadline of parent isn't ours to report as a timeout
+			if parent.Err() == nil {
+				r.setState(StateTimedOut)
+				return fmt.Errorf("task did not become %s within %v", taskStatusRunning, r.MaxWait)
+			}
+			return ctx.Err()
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
+
+// MetadataReceived returns a channel which is closed once the first task
+// metadata has been received
+func (r *Readiness) MetadataReceived() <-chan struct{} {
+	return r.received
+}
+
+// Task returns the latest task metadata, or nil if none has been received yet
+func (r *Readiness) Task() *TaskResponse {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.task
+}
+
+// State returns the current state
+func (r *Readiness) State() ReadinessState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// Status returns a snapshot of the current state
+func (r *Readiness) Status() ReadinessStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := ReadinessStatus{State: r.state, Since: r.since}
+	if r.lastErr != nil {
+		s.LastError = r.lastErr.Error()
+	}
+	return s
+}
+
+func (r *Readiness) update(task *TaskResponse, err error) {
+	r.mu.Lock()
+	r.lastErr = err
+	if task != nil {
+		if r.task == nil {
+			close(r.received)
+		}
+		r.task = task
+	}
+	r.mu.Unlock()
+
+	switch {
+	case task == nil:
+		// keep the current state, an error doesn't undo what we already know
+	case task.KnownStatus == taskStatusRunning:
+		r.setState(StateRunning)
+	default:
+		r.setState(StateWaitingForTask)
+	}
+}
+
+func (r *Readiness) setState(state ReadinessState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == state {
+		return
+	}
+	logger.With(logger.Fields{"from": r.state, "to": state}).Infof("readiness state changed")
+	r.state = state
+	r.since = time.Now()
+}
+
+// RunningContainers returns the containers of task which are RUNNING
+func RunningContainers(task *TaskResponse) []ContainerResponse {
+	var running []ContainerResponse
+	for _, con := range task.Containers {
+		if con.KnownStatus == taskStatusRunning {
+			running = append(running, con)
+		}
+	}
+	return running
+}
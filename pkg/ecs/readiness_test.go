@@ -0,0 +1,158 @@
+package ecs_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// scriptedSource returns a task of each of the statuses in turn, "" being an
+// error, then keeps returning the last one. It records the state of readiness
+// before every call.
+type scriptedSource struct {
+	statuses  []string
+	readiness *ecs.Readiness
+
+	mu     sync.Mutex
+	calls  int
+	states []ecs.ReadinessState
+}
+
+func (s *scriptedSource) TaskMetadata(ctx context.Context) (*ecs.TaskResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states = append(s.states, s.readiness.State())
+	status := s.statuses[len(s.statuses)-1]
+	if s.calls < len(s.statuses) {
+		status = s.statuses[s.calls]
+	}
+	s.calls++
+	if status == "" {
+		return nil, errors.New("connection refused")
+	}
+	return &ecs.TaskResponse{KnownStatus: status}, nil
+}
+
+func (s *scriptedSource) TaskStats(context.Context) (map[string]*types.Stats, error) {
+	return nil, nil
+}
+
+func TestReadiness(t *testing.T) {
+	const (
+		metadata = ecs.StateWaitingForMetadata
+		task     = ecs.StateWaitingForTask
+	)
+	cases := []struct {
+		name     string
+		statuses []string
+		maxWait  time.Duration
+		// states are the states before every call, and the final one
+		states  []ecs.ReadinessState
+		wantErr string
+	}{
+		{
+			name:     "running",
+			statuses: []string{"RUNNING"},
+			states:   []ecs.ReadinessState{metadata, ecs.StateRunning},
+		},
+		{
+			name:     "pending",
+			statuses: []string{"PENDING", "PENDING", "RUNNING"},
+			states:   []ecs.ReadinessState{metadata, task, task, ecs.StateRunning},
+		},
+		{
+			name:     "transient errors",
+			statuses: []string{"", "", "PENDING", "", "RUNNING"},
+			states:   []ecs.ReadinessState{metadata, metadata, metadata, task, task, ecs.StateRunning},
+		},
+		{
+			name:     "timed out",
+			statuses: []string{"", "PENDING"},
+			maxWait:  50 * time.Millisecond,
+			wantErr:  "task did not become RUNNING within 50ms",
+		},
+		{
+			name:     "timed out without metadata",
+			statuses: []string{""},
+			maxWait:  50 * time.Millisecond,
+			wantErr:  "task did not become RUNNING within 50ms",
+		},
+	}
+	for _, c := range cases {
+		source := &scriptedSource{statuses: c.statuses}
+		r := ecs.NewReadiness(source, c.maxWait)
+		r.PollInterval = time.Millisecond
+		source.readiness = r
+
+		err := r.Run(context.Background())
+		switch {
+		case c.wantErr == "" && err != nil:
+			t.Errorf("%s: got %v", c.name, err)
+		case c.wantErr != "" && (err == nil || err.Error() != c.wantErr):
+			t.Errorf("%s: got %v, want %s", c.name, err, c.wantErr)
+		}
+		if c.wantErr != "" {
+			if r.State() != ecs.StateTimedOut {
+				t.Errorf("%s: got the state %s", c.name, r.State())
+			}
+			continue
+		}
+		states := append(source.states, r.State())
+		if !reflect.DeepEqual(states, c.states) {
+			t.Errorf("%s: got the states %v, want %v", c.name, states, c.states)
+		}
+	}
+}
+
+func TestReadinessMetadataReceived(t *testing.T) {
+	source := &scriptedSource{statuses: []string{"", "PENDING", "", "PENDING", "RUNNING"}}
+	r := ecs.NewReadiness(source, 0)
+	r.PollInterval = time.Millisecond
+	source.readiness = r
+
+	select {
+	case <-r.MetadataReceived():
+		t.Fatal("MetadataReceived is closed before any metadata")
+	default:
+	}
+	// Run would panic if it closed the channel again
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.MetadataReceived():
+	default:
+		t.Error("MetadataReceived isn't closed")
+	}
+	if r.Task() == nil || r.Task().KnownStatus != "RUNNING" {
+		t.Errorf("got the task %+v", r.Task())
+	}
+	if s := r.Status(); s.State != ecs.StateRunning || s.LastError != "" {
+		t.Errorf("got the status %+v", s)
+	}
+}
+
+func TestReadinessCancelled(t *testing.T) {
+	source := &scriptedSource{statuses: []string{""}}
+	r := ecs.NewReadiness(source, 0)
+	r.PollInterval = time.Millisecond
+	source.readiness = r
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	s := r.Status()
+	if s.State != ecs.StateWaitingForMetadata || !strings.Contains(s.LastError, "connection refused") {
+		t.Errorf("got the status %+v", s)
+	}
+}
@@ -4,31 +4,9 @@
 package ecs
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
 	"time"
-
-	"github.com/docker/docker/api/types"
-)
-
-const (
-	containerMetadataEnvVar = "ECS_CONTAINER_METADATA_URI"
-	maxRetries              = 4
-	durationBetweenRetries  = time.Second
 )
 
-var taskMetadataPath string
-var taskStatsPath string
-
-func init() {
-	v3BaseEndpoint := os.Getenv(containerMetadataEnvVar)
-	taskMetadataPath = v3BaseEndpoint + "/task"
-	taskStatsPath = v3BaseEndpoint + "/task/stats"
-}
-
 // TaskResponse defines the schema for the task response JSON object
 type TaskResponse struct {
 	Cluster            string              `json:"Cluster"`
@@ -43,6 +21,32 @@ type TaskResponse struct {
 	PullStartedAt      *time.Time          `json:"PullStartedAt,omitempty"`
 	PullStoppedAt      *time.Time          `json:"PullStoppedAt,omitempty"`
 	ExecutionStoppedAt *time.Time          `json:"ExecutionStoppedAt,omitempty"`
+
+	// ServiceName and Group, e.g. "service:foo", aren't returned by every
+	// version of the endpoint, see TaskIdentity
+	ServiceName string `json:"ServiceName,omitempty"`
+	Group       string `json:"Group,omitempty"`
+
+	// TaskTags and ContainerInstanceTags are only returned by the
+	// `/taskWithTags` path of the Task Metadata Endpoint v4
+	TaskTags              map[string]string `json:"TaskTags,omitempty"`
+	ContainerInstanceTags map[string]string `json:"ContainerInstanceTags,omitempty"`
+
+	// LaunchType and EphemeralStorageMetrics are only returned by the Task
+	// Metadata Endpoint v4, the latter on Fargate platform version 1.4.0 or
+	// later
+	LaunchType              string                   `json:"LaunchType,omitempty"`
+	EphemeralStorageMetrics *EphemeralStorageMetrics `json:"EphemeralStorageMetrics,omitempty"`
+}
+
+// LaunchTypeFargate is the LaunchType of the tasks running on Fargate
+const LaunchTypeFargate = "FARGATE"
+
+// EphemeralStorageMetrics defines the schema for the ephemeral storage usage
+// of a Fargate task, in MiB
+type EphemeralStorageMetrics struct {
+	Utilized int64 `json:"Utilized"`
+	Reserved int64 `json:"Reserved"`
 }
 
 // ContainerResponse defines the schema for the container response
@@ -102,76 +106,3 @@ type Network struct {
 func IsPauseContainer(containerMetadata ContainerResponse) bool {
 	return containerMetadata.Type == "CNI_PAUSE"
 }
-
-// GetTaskMetadata returns the ECS task's metadata by making the api call to
-// the Task Metadata endpoint v3
-func GetTaskMetadata(client *http.Client) (*TaskResponse, error) {
-	var err error
-	body, err := metadataResponse(client, taskMetadataPath)
-	if err != nil {
-		return nil, err
-	}
-
-	//fmt.Printf("received task metadata: %s \n", string(body))
-
-	var taskMetadata TaskResponse
-	if err = json.Unmarshal(body, &taskMetadata); err != nil {
-		return nil, fmt.Errorf("unable to parse response body: %v", err)
-	}
-
-	return &taskMetadata, nil
-}
-
-// GetTaskMetadata returns stats of the ECS task's containers by making the api
-// call to the Task Metadata endpoint v3
-func GetTaskStats(client *http.Client) (map[string]*types.Stats, error) {
-	body, err := metadataResponse(client, taskStatsPath)
-	if err != nil {
-		return nil, err
-	}
-
-	//fmt.Printf("received task stats: %s \n", string(body))
-
-	var taskStats map[string]*types.Stats
-	err = json.Unmarshal(body, &taskStats)
-	if err != nil {
-		return nil, fmt.Errorf("task stats: unable to parse response body: %v", err)
-	}
-
-	return taskStats, nil
-}
-
-func metadataResponse(client *http.Client, endpoint string) ([]byte, error) {
-	var resp []byte
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		resp, err = metadataResponseOnce(client, endpoint)
-		if err == nil {
-			return resp, nil
-		}
-		fmt.Fprintf(os.Stderr, "Attempt [%d/%d]: unable to get metadata response from '%s': %v",
-			i, maxRetries, endpoint, err)
-		time.Sleep(durationBetweenRetries)
-	}
-
-	return nil, err
-}
-
-func metadataResponseOnce(client *http.Client, endpoint string) ([]byte, error) {
-	resp, err := client.Get(endpoint)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
-	}
-	if resp.Body != nil {
-		defer resp.Body.Close()
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("incorrect status code  %d", resp.StatusCode)
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read response body: %v", err)
-	}
-
-	return body, nil
-}
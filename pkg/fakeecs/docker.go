@@ -0,0 +1,153 @@
+package fakeecs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// DockerServer is an http.Handler serving the part of the Docker Engine API
+// the sidecar uses, `/containers/json` and `/containers/{id}/stats`, from a
+// Scenario. The containers carry the labels the ECS agent puts on them.
+//
+// The scenario advances by one sample every interval, whatever the interval
+// of the Read times it synthesizes, so that streams can be sub-second.
+type DockerServer struct {
+	scenario Scenario
+	interval time.Duration
+	start    time.Time
+}
+
+// NewDockerServer returns a DockerServer for the given scenario, streaming a
+// sample every interval
+func NewDockerServer(scenario Scenario, interval time.Duration) *DockerServer {
+	return &DockerServer{scenario: scenario, interval: interval, start: time.Now()}
+}
+
+func (s *DockerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The API version prefix, e.g. /v1.39, is optional
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "v1.") {
+		parts = parts[1:]
+	}
+
+	switch {
+	case len(parts) == 2 && parts[0] == "containers" && parts[1] == "json":
+		s.serveList(w, r)
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "stats":
+		s.serveStats(w, r, parts[1])
+	default:
+		writeDockerError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// serveList lists the running containers matching the label filters
+func (s *DockerServer) serveList(w http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeDockerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	task := s.scenario.Task(s.sample())
+
+	containers := []types.Container{}
+	for _, con := range task.Containers {
+		c := dockerContainer(task, con)
+		if args.MatchKVList("label", c.Labels) {
+			containers = append(containers, c)
+		}
+	}
+	writeJSON(w, containers)
+}
+
+// dockerContainer returns how Docker lists con, a container of task
+func dockerContainer(task *ecs.TaskResponse, con ecs.ContainerResponse) types.Container {
+	labels := map[string]string{
+		ecs.LabelCluster:                task.Cluster,
+		ecs.LabelContainerName:          con.Name,
+		ecs.LabelTaskARN:                task.TaskARN,
+		ecs.LabelTaskDefinitionFamily:   task.Family,
+		ecs.LabelTaskDefinitionRevision: task.Revision,
+	}
+	for k, v := range con.Labels {
+		labels[k] = v
+	}
+	return types.Container{
+		ID:      con.ID,
+		Names:   []string{"/" + con.DockerName},
+		Image:   con.Image,
+		ImageID: con.ImageID,
+		Labels:  labels,
+		State:   strings.ToLower(con.KnownStatus),
+		Status:  "Up",
+	}
+}
+
+// serveStats streams the stats of the container id until it stops or the
+// client goes away, or returns one sample if stream=false
+func (s *DockerServer) serveStats(w http.ResponseWriter, r *http.Request, id string) {
+	stats, ok := s.next(id)
+	if !ok {
+		writeDockerError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if r.URL.Query().Get("stream") == "false" {
+		enc.Encode(stats)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := enc.Encode(stats); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		// A restarted container has a new ID, which ends the stream the way
+		// Docker does when a container stops
+		if stats, ok = s.next(id); !ok {
+			return
+		}
+	}
+}
+
+// sample returns the number of the current sample of the scenario
+func (s *DockerServer) sample() int {
+	return int(time.Since(s.start) / s.interval)
+}
+
+// next returns the current sample of the container id, if it is still there
+func (s *DockerServer) next(id string) (*types.StatsJSON, bool) {
+	n := s.sample()
+	stats, ok := s.scenario.Stats(n)[id]
+	if !ok || stats == nil {
+		return nil, false
+	}
+	return statsJSON(s.scenario.Task(n), id, stats, n), true
+}
+
+func writeDockerError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(types.ErrorResponse{Message: message})
+}
@@ -0,0 +1,65 @@
+package fakeecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// fixtures replays responses recorded from a real Task Metadata Endpoint
+type fixtures struct {
+	task  *ecs.TaskResponse
+	stats []map[string]*types.Stats
+}
+
+// LoadFixtures returns a Scenario replaying the recorded responses in dir.
+// dir must contain `task.json` and one or more `stats*.json` files, which are
+// served in lexical order and then repeated from the first one.
+func LoadFixtures(dir string) (Scenario, error) {
+	f := &fixtures{}
+	if err := readJSON(filepath.Join(dir, "task.json"), &f.task); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "stats*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no stats*.json fixtures found in %s", dir)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var stats map[string]*types.Stats
+		if err := readJSON(path, &stats); err != nil {
+			return nil, err
+		}
+		f.stats = append(f.stats, stats)
+	}
+	return f, nil
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read fixture: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unable to parse fixture %s: %v", path, err)
+	}
+	return nil
+}
+
+func (f *fixtures) Task(int) *ecs.TaskResponse {
+	return f.task
+}
+
+func (f *fixtures) Stats(n int) map[string]*types.Stats {
+	return f.stats[n%len(f.stats)]
+}
@@ -0,0 +1,256 @@
+package fakeecs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+const (
+	fakeCluster = "fake"
+	fakeTaskARN = "arn:aws:ecs:us-west-2:123456789012:task/fake/0123456789abcdef0123456789abcdef"
+	fakeFamily  = "fake-app"
+
+	onlineCPUs     = 2
+	memoryLimit    = 512 * 1024 * 1024
+	sampleInterval = 10 * time.Second
+)
+
+var startedAt = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// container describes one synthesized container. cpu and memory return the
+// CPU utilization (percent) and memory usage (bytes) for the given sample.
+type container struct {
+	name          string
+	containerType string
+	cpu           func(n int) float64
+	memory        func(n int) uint64
+	// restartEvery makes the container restart, with a new ID and reset
+	// counters, every restartEvery samples. 0 means it never restarts.
+	restartEvery int
+}
+
+type synthesized struct {
+	containers []container
+	// ephemeralStorage returns the MiB of ephemeral storage used by the task
+	// at the given sample, if it runs on Fargate
+	ephemeralStorage func(n int) int64
+}
+
+// Scenarios maps the scenario names accepted by the fakeecs command to their
+// constructors.
+var Scenarios = map[string]func() Scenario{
+	"steady":     Steady,
+	"rising-cpu": RisingCPU,
+	"restarting": Restarting,
+	"awsvpc":     AWSVPC,
+	"fargate":    Fargate,
+}
+
+// ScenarioNames returns the names of the synthesized scenarios in a stable order
+func ScenarioNames() []string {
+	var names []string
+	for name := range Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Steady returns a task with an application container and this sidecar, both
+// running at a constant CPU and memory utilization.
+func Steady() Scenario {
+	return &synthesized{containers: []container{
+		appContainer(constantCPU(25)),
+		sidecarContainer(),
+	}}
+}
+
+// RisingCPU returns a task whose application container's CPU utilization
+// rises by 5% every sample until it saturates at 100%.
+func RisingCPU() Scenario {
+	return &synthesized{containers: []container{
+		appContainer(func(n int) float64 {
+			if p := 5.0 * float64(n+1); p < 100.0 {
+				return p
+			}
+			return 100.0
+		}),
+		sidecarContainer(),
+	}}
+}
+
+// Restarting returns a task whose application container restarts every 6
+// samples, changing its Docker ID and resetting its CPU counters.
+func Restarting() Scenario {
+	app := appContainer(constantCPU(40))
+	app.restartEvery = 6
+	return &synthesized{containers: []container{
+		app,
+		sidecarContainer(),
+	}}
+}
+
+// AWSVPC returns the Steady task running with the awsvpc networking mode,
+// which adds the CNI pause container to both the metadata and the stats.
+func AWSVPC() Scenario {
+	return &synthesized{containers: []container{
+		{
+			name:          "~internal~ecs~pause",
+			containerType: "CNI_PAUSE",
+			cpu:           constantCPU(0),
+			memory:        constantMemory(1024 * 1024),
+		},
+		appContainer(constantCPU(25)),
+		sidecarContainer(),
+	}}
+}
+
+// fargateEphemeralStorage is the MiB of ephemeral storage Fargate reserves
+// for a task by default
+const fargateEphemeralStorage = 20496
+
+// Fargate returns the AWSVPC task running on Fargate, whose ephemeral storage
+// usage grows by 64 MiB every sample until it is full.
+func Fargate() Scenario {
+	s := AWSVPC().(*synthesized)
+	s.ephemeralStorage = func(n int) int64 {
+		if used := 261 + 64*int64(n); used < fargateEphemeralStorage {
+			return used
+		}
+		return fargateEphemeralStorage
+	}
+	return s
+}
+
+func appContainer(cpu func(n int) float64) container {
+	return container{
+		name:          "app",
+		containerType: "NORMAL",
+		cpu:           cpu,
+		memory:        constantMemory(256 * 1024 * 1024),
+	}
+}
+
+func sidecarContainer() container {
+	return container{
+		name:          "taskmetadata-cloudwatch",
+		containerType: "NORMAL",
+		cpu:           constantCPU(1),
+		memory:        constantMemory(8 * 1024 * 1024),
+	}
+}
+
+func constantCPU(percent float64) func(int) float64 {
+	return func(int) float64 { return percent }
+}
+
+func constantMemory(bytes uint64) func(int) uint64 {
+	return func(int) uint64 { return bytes }
+}
+
+// generation returns how many times c has restarted and how many samples it
+// has produced since its last restart
+func (c container) generation(n int) (gen, local int) {
+	if c.restartEvery <= 0 {
+		return 0, n
+	}
+	return n / c.restartEvery, n % c.restartEvery
+}
+
+func (c container) id(n int) string {
+	gen, _ := c.generation(n)
+	return fmt.Sprintf("%s-%d", c.name, gen)
+}
+
+func (s *synthesized) Task(n int) *ecs.TaskResponse {
+	cpu := float64(onlineCPUs)
+	memory := int64(memoryLimit / (1024 * 1024))
+	task := &ecs.TaskResponse{
+		Cluster:          fakeCluster,
+		TaskARN:          fakeTaskARN,
+		Family:           fakeFamily,
+		Revision:         "1",
+		ServiceName:      fakeFamily,
+		DesiredStatus:    "RUNNING",
+		KnownStatus:      "RUNNING",
+		AvailabilityZone: "us-west-2a",
+		Limits:           &ecs.LimitsResponse{CPU: &cpu, Memory: &memory},
+		TaskTags:         map[string]string{"team": "payments", "cost-center": "1234"},
+	}
+	if s.ephemeralStorage != nil {
+		task.LaunchType = ecs.LaunchTypeFargate
+		task.EphemeralStorageMetrics = &ecs.EphemeralStorageMetrics{
+			Utilized: s.ephemeralStorage(n),
+			Reserved: fargateEphemeralStorage,
+		}
+	}
+	for _, c := range s.containers {
+		task.Containers = append(task.Containers, ecs.ContainerResponse{
+			ID:            c.id(n),
+			Name:          c.name,
+			DockerName:    fmt.Sprintf("ecs-%s-1-%s", fakeFamily, c.name),
+			Image:         c.name + ":latest",
+			DesiredStatus: "RUNNING",
+			KnownStatus:   "RUNNING",
+			Type:          c.containerType,
+		})
+	}
+	return task
+}
+
+func (s *synthesized) Stats(n int) map[string]*types.Stats {
+	stats := make(map[string]*types.Stats, len(s.containers))
+	for _, c := range s.containers {
+		stats[c.id(n)] = c.stats(n)
+	}
+	return stats
+}
+
+// stats synthesizes the Docker stats of c at sample n. The CPU counters are
+// cumulative, as they are in real Docker stats, so that the utilization
+// computed from the CPUStats/PreCPUStats pair matches c.cpu(n).
+func (c container) stats(n int) *types.Stats {
+	_, local := c.generation(n)
+	read := startedAt.Add(time.Duration(n) * sampleInterval)
+	s := &types.Stats{
+		Read:     read,
+		CPUStats: c.cpuStats(n, local),
+		MemoryStats: types.MemoryStats{
+			Usage:    c.memory(n),
+			MaxUsage: c.memory(n),
+			Limit:    memoryLimit,
+			Stats:    map[string]uint64{"cache": 0},
+		},
+	}
+	// Docker reports empty previous stats for the first sample of a container
+	if local > 0 {
+		s.PreRead = read.Add(-sampleInterval)
+		s.PreCPUStats = c.cpuStats(n-1, local-1)
+	}
+	return s
+}
+
+func (c container) cpuStats(n, local int) types.CPUStats {
+	systemDelta := uint64(sampleInterval.Nanoseconds()) * onlineCPUs
+	var total uint64
+	for i := n - local; i <= n; i++ {
+		total += uint64(c.cpu(i) / 100.0 * float64(systemDelta) / onlineCPUs)
+	}
+	percpu := make([]uint64, onlineCPUs)
+	for i := range percpu {
+		percpu[i] = total / onlineCPUs
+	}
+	return types.CPUStats{
+		CPUUsage: types.CPUUsage{
+			TotalUsage:  total,
+			PercpuUsage: percpu,
+		},
+		SystemUsage: uint64(n+1) * systemDelta,
+		OnlineCPUs:  onlineCPUs,
+	}
+}
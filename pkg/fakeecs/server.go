@@ -0,0 +1,95 @@
+// Package fakeecs serves a fake ECS Task Metadata Endpoint v3, or v4, so that
+// the sidecar can be run and exercised without being inside an ECS task.
+
+package fakeecs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+// Scenario provides the responses served by the fake endpoint. n is the
+// number of `/task/stats` responses served so far, which lets a scenario
+// evolve over time (rising load, restarts and so on).
+type Scenario interface {
+	Task(n int) *ecs.TaskResponse
+	Stats(n int) map[string]*types.Stats
+}
+
+// Server is an http.Handler serving `/task`, `/taskWithTags` and `/task/stats`
+// from a Scenario. Any path prefix is accepted, so it can be mounted under a
+// v3-style base URL such as `http://localhost:8080/v3/<id>`.
+type Server struct {
+	scenario Scenario
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewServer returns a Server for the given scenario
+func NewServer(scenario Scenario) *Server {
+	return &Server{scenario: scenario}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(path, "/task/stats"):
+		task := s.scenario.Task(s.n)
+		stats := make(map[string]*types.StatsJSON)
+		for id, st := range s.scenario.Stats(s.n) {
+			stats[id] = statsJSON(task, id, st, s.n)
+		}
+		writeJSON(w, stats)
+		s.n++
+	case strings.HasSuffix(path, "/taskWithTags"):
+		writeJSON(w, s.scenario.Task(s.n))
+	case strings.HasSuffix(path, "/task"):
+		// Only /taskWithTags returns the tags
+		task := *s.scenario.Task(s.n)
+		task.TaskTags, task.ContainerInstanceTags = nil, nil
+		writeJSON(w, &task)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// statsJSON returns stats, the n-th sample of the container id of task, the
+// way Docker and the endpoint v4 report it: with the name and the ID of the
+// container, and its network usage growing by 1 KiB received and 512 bytes
+// sent per sample
+func statsJSON(task *ecs.TaskResponse, id string, stats *types.Stats, n int) *types.StatsJSON {
+	if stats == nil {
+		return nil
+	}
+	st := &types.StatsJSON{Stats: *stats, ID: id, Networks: map[string]types.NetworkStats{
+		"eth0": {RxBytes: uint64(n) * 1024, RxPackets: uint64(n), TxBytes: uint64(n) * 512, TxPackets: uint64(n)},
+	}}
+	for _, con := range task.Containers {
+		if con.ID == id {
+			st.Name = "/" + con.DockerName
+		}
+	}
+	return st
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,95 @@
+package fakeecs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+)
+
+func get(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: unable to decode the response: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(NewServer(Restarting()))
+	defer server.Close()
+	base := server.URL + "/v4/fake"
+
+	var task ecs.TaskResponse
+	if code := get(t, base+"/task", &task); code != http.StatusOK {
+		t.Fatalf("/task: got status %d", code)
+	}
+	if task.TaskARN != fakeTaskARN || len(task.Containers) != 2 {
+		t.Errorf("/task: got %+v", task)
+	}
+	if len(task.TaskTags) != 0 {
+		t.Errorf("/task: got tags %v, only /taskWithTags returns them", task.TaskTags)
+	}
+	var tagged ecs.TaskResponse
+	get(t, base+"/taskWithTags", &tagged)
+	if tagged.TaskTags["team"] != "payments" {
+		t.Errorf("/taskWithTags: got tags %v", tagged.TaskTags)
+	}
+	if code := get(t, base+"/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("/unknown: got status %d, want 404", code)
+	}
+
+	// Every stats response advances the scenario by one sample
+	for n := 0; n < 7; n++ {
+		var stats map[string]*types.Stats
+		get(t, base+"/task/stats", &stats)
+		want := "app-0"
+		if n >= 6 {
+			want = "app-1"
+		}
+		if _, ok := stats[want]; !ok || len(stats) != 2 {
+			t.Errorf("sample %d: got the stats of %v, want %s", n, keys(stats), want)
+		}
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	dirs, err := filepath.Glob("testdata/*")
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+	for _, dir := range dirs {
+		s, err := LoadFixtures(dir)
+		if err != nil {
+			t.Errorf("%s: %v", dir, err)
+			continue
+		}
+		task := s.Task(0)
+		stats := s.Stats(0)
+		for _, con := range task.Containers {
+			if _, ok := stats[con.ID]; !ok && !ecs.IsPauseContainer(con) {
+				t.Errorf("%s: no stats for the container %s", dir, con.Name)
+			}
+		}
+	}
+}
+
+func keys(m map[string]*types.Stats) []string {
+	var k []string
+	for id := range m {
+		k = append(k, id)
+	}
+	return k
+}
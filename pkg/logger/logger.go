@@ -0,0 +1,230 @@
+// Package logger is a small leveled logger writing either human readable text
+// or one JSON object per line, with identical warnings and errors rate limited.
+
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level named s, e.g. "info"
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, must be one of debug, info, warn, error", s)
+}
+
+// Format is the output format of a Logger
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the Format named s, e.g. "json"
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	}
+	return FormatText, fmt.Errorf("unknown log format %q, must be one of text, json", s)
+}
+
+// Fields are the structured context of a log entry, e.g. the task ARN
+type Fields map[string]interface{}
+
+// RepeatWindow is how long identical warnings and errors are suppressed for
+// after being logged. The number of suppressed entries is reported in the
+// "repeated" field of the next one.
+const RepeatWindow = time.Minute
+
+// Logger writes leveled, structured log entries. Loggers derived with With
+// share their output and rate limiting.
+type Logger struct {
+	*output
+	fields Fields
+}
+
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format Format
+	// repeats tracks the identical warnings and errors logged recently
+	repeats map[string]*repeat
+}
+
+type repeat struct {
+	last       time.Time
+	suppressed int
+}
+
+// New returns a Logger writing entries of level and above to w
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{output: &output{
+		w:       w,
+		level:   level,
+		format:  format,
+		repeats: make(map[string]*repeat),
+	}}
+}
+
+// std holds the *Logger of the package-level functions. It is replaced while
+// other goroutines log, e.g. on reload.
+var std atomic.Value
+
+func init() {
+	std.Store(New(os.Stderr, LevelInfo, FormatText))
+}
+
+// Default returns the logger used by the package-level functions
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+// SetDefault replaces the logger used by the package-level functions. It is
+// safe to call while other goroutines log.
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+// With returns a Logger adding fields to every entry
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{output: l.output, fields: merged}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(LevelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(LevelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(LevelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(LevelError, format, args...) }
+
+// With returns the default Logger adding fields to every entry
+func With(fields Fields) *Logger { return Default().With(fields) }
+
+func Debugf(format string, args ...interface{}) { Default().log(LevelDebug, format, args...) }
+func Infof(format string, args ...interface{})  { Default().log(LevelInfo, format, args...) }
+func Warnf(format string, args ...interface{})  { Default().log(LevelWarn, format, args...) }
+func Errorf(format string, args ...interface{}) { Default().log(LevelError, format, args...) }
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fields := l.fields
+	if level >= LevelWarn {
+		key := level.String() + "\x00" + msg + "\x00" + fmt.Sprint(l.fields)
+		r, ok := l.repeats[key]
+		if ok && now.Sub(r.last) < RepeatWindow {
+			r.suppressed++
+			return
+		}
+		if ok && r.suppressed > 0 {
+			fields = Fields{"repeated": r.suppressed}
+			for k, v := range l.fields {
+				fields[k] = v
+			}
+		}
+		l.repeats[key] = &repeat{last: now}
+		l.forgetRepeats(now)
+	}
+
+	if l.format == FormatJSON {
+		l.writeJSON(now, level, msg, fields)
+	} else {
+		l.writeText(now, level, msg, fields)
+	}
+}
+
+// forgetRepeats drops the entries whose window is over and which weren't
+// repeated, so that distinct messages don't accumulate forever
+func (o *output) forgetRepeats(now time.Time) {
+	for key, r := range o.repeats {
+		if r.suppressed == 0 && now.Sub(r.last) >= RepeatWindow {
+			delete(o.repeats, key)
+		}
+	}
+}
+
+func (o *output) writeJSON(now time.Time, level Level, msg string, fields Fields) {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = now.UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{
+			"time":  entry["time"].(string),
+			"level": level.String(),
+			"msg":   msg,
+			"error": "unable to encode log fields: " + err.Error(),
+		})
+	}
+	o.w.Write(append(b, '\n'))
+}
+
+func (o *output) writeText(now time.Time, level Level, msg string, fields Fields) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", now.UTC().Format(time.RFC3339), strings.ToUpper(level.String()), msg)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	b.WriteByte('\n')
+	io.WriteString(o.w, b.String())
+}
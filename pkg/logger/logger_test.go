@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSetDefaultConcurrently is meant for the race detector: the default
+// logger is replaced on reload while other goroutines log
+func TestSetDefaultConcurrently(t *testing.T) {
+	defer SetDefault(Default())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				With(Fields{"goroutine": j}).Debugf("debug")
+				Infof("info")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		SetDefault(New(ioutil.Discard, LevelDebug, FormatJSON).With(Fields{"taskArn": "arn"}))
+	}
+	wg.Wait()
+
+	var buf bytes.Buffer
+	SetDefault(New(&buf, LevelInfo, FormatText))
+	Infof("replaced")
+	if !strings.Contains(buf.String(), "replaced") {
+		t.Errorf("the replaced logger wasn't used, got %q", buf.String())
+	}
+}
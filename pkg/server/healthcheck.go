@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Check gets url and returns an error unless it responds 200 OK within
+// timeout. It backs the healthcheck command, so that the image doesn't need
+// curl to be used as a container health check.
+func Check(url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Package server serves the sidecar's own HTTP endpoints, such as its health.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/collector"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
+)
+
+// Options are what the endpoints report on
+type Options struct {
+	Readiness *ecs.Readiness
+	Collector *collector.Collector
+	// MaxPublishAge is how long /readyz tolerates no successful publish once
+	// the task is RUNNING
+	MaxPublishAge time.Duration
+}
+
+// ready is the body of /readyz
+type ready struct {
+	ecs.ReadinessStatus
+	LastPublishSuccess *time.Time `json:"lastPublishSuccess,omitempty"`
+	Reason             string     `json:"reason,omitempty"`
+}
+
+// NewHandler returns the handler of the sidecar's HTTP endpoints:
+//
+//	/healthz       200 as long as the process is alive
+//	/readyz        200 once the task is RUNNING and a publish succeeded within
+//	               MaxPublishAge, 503 otherwise. The body reports why as JSON.
+//	/metrics       the sidecar's own metrics in the Prometheus text format
+//	/debug/last    the last collected sample and its datums as JSON
+//	/debug/config  the effective configuration as JSON
+func NewHandler(opts Options) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		body := ready{ReadinessStatus: opts.Readiness.Status()}
+		code := http.StatusOK
+		if last := telemetry.Default.LastPublishSuccess(); !last.IsZero() {
+			body.LastPublishSuccess = &last
+		}
+		if reason := notReadyReason(body, opts.MaxPublishAge); reason != "" {
+			body.Reason = reason
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, body)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		telemetry.Default.WritePrometheus(w)
+	})
+	mux.HandleFunc("/debug/last", func(w http.ResponseWriter, r *http.Request) {
+		last := opts.Collector.Last()
+		if last == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "nothing collected yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, last)
+	})
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, opts.Collector.Config())
+	})
+	return mux
+}
+
+// notReadyReason returns why the sidecar isn't ready, or "" if it is. Until
+// the first publish, the time the task became RUNNING stands for it.
+func notReadyReason(body ready, maxPublishAge time.Duration) string {
+	if body.State != ecs.StateRunning {
+		return fmt.Sprintf("task is not running yet: %s", body.State)
+	}
+	if maxPublishAge <= 0 {
+		return ""
+	}
+	last := body.Since
+	if body.LastPublishSuccess != nil && body.LastPublishSuccess.After(last) {
+		last = *body.LastPublishSuccess
+	}
+	if age := time.Since(last); age > maxPublishAge {
+		return fmt.Sprintf("no successful publish for %v", age.Round(time.Second))
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
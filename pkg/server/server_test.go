@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
+)
+
+// statusSource is an ecs.MetadataSource of a task whose status is status
+type statusSource string
+
+func (s statusSource) TaskMetadata(context.Context) (*ecs.TaskResponse, error) {
+	return &ecs.TaskResponse{KnownStatus: string(s)}, nil
+}
+
+func (statusSource) TaskStats(context.Context) (map[string]*types.Stats, error) {
+	return nil, nil
+}
+
+// readiness returns a Readiness which went through the task metadata once
+func readiness(status string) *ecs.Readiness {
+	r := ecs.NewReadiness(statusSource(status), 10*time.Millisecond)
+	r.PollInterval = time.Millisecond
+	r.Run(context.Background())
+	return r
+}
+
+func TestReadyz(t *testing.T) {
+	defer func(m *telemetry.Metrics) { telemetry.Default = m }(telemetry.Default)
+
+	cases := []struct {
+		name      string
+		readiness func() *ecs.Readiness
+		// wait is how long to wait after the readiness check
+		wait          time.Duration
+		published     bool
+		maxPublishAge time.Duration
+		code          int
+		reason        string
+	}{
+		{
+			name:      "no metadata",
+			readiness: func() *ecs.Readiness { return ecs.NewReadiness(statusSource("RUNNING"), 0) },
+			code:      http.StatusServiceUnavailable,
+			reason:    "task is not running yet: WaitingForMetadata",
+		},
+		{
+			name:      "pending",
+			readiness: func() *ecs.Readiness { return readiness("PENDING") },
+			code:      http.StatusServiceUnavailable,
+			reason:    "task is not running yet: TimedOut",
+		},
+		{
+			name:          "running, not published yet",
+			readiness:     func() *ecs.Readiness { return readiness("RUNNING") },
+			maxPublishAge: time.Minute,
+			code:          http.StatusOK,
+		},
+		{
+			name:          "running, never published",
+			readiness:     func() *ecs.Readiness { return readiness("RUNNING") },
+			wait:          50 * time.Millisecond,
+			maxPublishAge: 20 * time.Millisecond,
+			code:          http.StatusServiceUnavailable,
+			reason:        "no successful publish for",
+		},
+		{
+			name:          "running, published",
+			readiness:     func() *ecs.Readiness { return readiness("RUNNING") },
+			wait:          50 * time.Millisecond,
+			published:     true,
+			maxPublishAge: 20 * time.Millisecond,
+			code:          http.StatusOK,
+		},
+		{
+			name:      "running, age not checked",
+			readiness: func() *ecs.Readiness { return readiness("RUNNING") },
+			wait:      50 * time.Millisecond,
+			code:      http.StatusOK,
+		},
+	}
+	for _, c := range cases {
+		telemetry.Default = telemetry.New()
+		handler := NewHandler(Options{Readiness: c.readiness(), MaxPublishAge: c.maxPublishAge})
+		time.Sleep(c.wait)
+		if c.published {
+			telemetry.Default.ObservePublish(telemetry.PublishSuccess, 1, time.Millisecond)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body ready
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if w.Code != c.code {
+			t.Errorf("%s: got %d, want %d", c.name, w.Code, c.code)
+		}
+		if c.reason == "" && body.Reason != "" || !strings.HasPrefix(body.Reason, c.reason) {
+			t.Errorf("%s: got the reason %q, want %q", c.name, body.Reason, c.reason)
+		}
+		if c.published != (body.LastPublishSuccess != nil) {
+			t.Errorf("%s: got the last publish success %v", c.name, body.LastPublishSuccess)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package telemetry tracks the sidecar's own health: how often it collects,
+// how its calls to the metadata endpoint and to CloudWatch go, and how much
+// data is waiting to be sent.
+
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// PublishResult is the outcome of a PutMetricData call
+type PublishResult string
+
+const (
+	PublishSuccess   PublishResult = "success"
+	PublishFailure   PublishResult = "failure"
+	PublishThrottled PublishResult = "throttled"
+)
+
+// Metrics are the sidecar's internal counters
+type Metrics struct {
+	mu sync.Mutex
+
+	collectionCycles   int64
+	metadataErrors     int64
+	publishes          map[PublishResult]int64
+	datumsSent         int64
+	publishLatencySum  time.Duration
+	publishLatencyN    int64
+	bufferedDatums     map[string]int64
+	lastPublishSuccess time.Time
+	skippedSamples     map[string]int64
+
+	// reported holds the counters as of the last call to Datums
+	reported *Metrics
+}
+
+// Default is the Metrics the sidecar records into
+var Default = New()
+
+// New returns zeroed Metrics
+func New() *Metrics {
+	return &Metrics{
+		publishes:      make(map[PublishResult]int64),
+		bufferedDatums: make(map[string]int64),
+		skippedSamples: make(map[string]int64),
+	}
+}
+
+// IncCollectionCycles counts one collection cycle
+func (m *Metrics) IncCollectionCycles() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectionCycles++
+}
+
+// IncMetadataErrors counts one failed call to the metadata endpoint
+func (m *Metrics) IncMetadataErrors() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metadataErrors++
+}
+
+// IncSkippedSamples counts one container sample whose CPU utilization, or
+// every metric, wasn't reported, for the given reason
+func (m *Metrics) IncSkippedSamples(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skippedSamples[reason]++
+}
+
+func (m *Metrics) totalSkippedSamples() int64 {
+	var total int64
+	for _, n := range m.skippedSamples {
+		total += n
+	}
+	return total
+}
+
+// ObservePublish records one PutMetricData call of datums datums
+func (m *Metrics) ObservePublish(result PublishResult, datums int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.publishes[result]++
+	m.publishLatencySum += latency
+	m.publishLatencyN++
+	if result == PublishSuccess {
+		m.datumsSent += int64(datums)
+		m.lastPublishSuccess = time.Now()
+	}
+}
+
+// SetBufferedDatums records how many datums are waiting to be sent by sink
+func (m *Metrics) SetBufferedDatums(sink string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bufferedDatums[sink] = int64(n)
+}
+
+func (m *Metrics) totalBufferedDatums() int64 {
+	var total int64
+	for _, n := range m.bufferedDatums {
+		total += n
+	}
+	return total
+}
+
+// LastPublishSuccess returns when PutMetricData last succeeded, or the zero
+// time if it never did
+func (m *Metrics) LastPublishSuccess() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastPublishSuccess
+}
+
+const prometheusPrefix = "taskmetadata_cloudwatch_"
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	write := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	metric := func(name, typ, help string) {
+		write("# HELP %s%s %s\n# TYPE %s%s %s\n", prometheusPrefix, name, help, prometheusPrefix, name, typ)
+	}
+
+	metric("collection_cycles_total", "counter", "Number of collection cycles.")
+	write("%scollection_cycles_total %d\n", prometheusPrefix, m.collectionCycles)
+	metric("metadata_errors_total", "counter", "Number of failed calls to the task metadata endpoint.")
+	write("%smetadata_errors_total %d\n", prometheusPrefix, m.metadataErrors)
+	metric("skipped_samples_total", "counter", "Number of container samples not or partly reported by reason.")
+	reasons := make([]string, 0, len(m.skippedSamples))
+	for reason := range m.skippedSamples {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		write("%sskipped_samples_total{reason=%q} %d\n", prometheusPrefix, reason, m.skippedSamples[reason])
+	}
+	metric("put_metric_data_total", "counter", "Number of PutMetricData calls by result.")
+	for _, result := range []PublishResult{PublishSuccess, PublishFailure, PublishThrottled} {
+		write("%sput_metric_data_total{result=%q} %d\n", prometheusPrefix, result, m.publishes[result])
+	}
+	metric("datums_sent_total", "counter", "Number of datums successfully sent to CloudWatch.")
+	write("%sdatums_sent_total %d\n", prometheusPrefix, m.datumsSent)
+	metric("publish_latency_seconds", "summary", "Latency of the PutMetricData calls.")
+	write("%spublish_latency_seconds_sum %g\n", prometheusPrefix, m.publishLatencySum.Seconds())
+	write("%spublish_latency_seconds_count %d\n", prometheusPrefix, m.publishLatencyN)
+	metric("buffered_datums", "gauge", "Number of datums waiting to be sent.")
+	write("%sbuffered_datums %d\n", prometheusPrefix, m.totalBufferedDatums())
+	metric("last_publish_success_timestamp_seconds", "gauge", "Unix time of the last successful PutMetricData call.")
+	var last float64
+	if !m.lastPublishSuccess.IsZero() {
+		last = float64(m.lastPublishSuccess.UnixNano()) / float64(time.Second)
+	}
+	write("%slast_publish_success_timestamp_seconds %g\n", prometheusPrefix, last)
+	return err
+}
+
+// Datums returns the metrics as CloudWatch metric data. Counters are reported
+// as their increase since the previous call, so that they can be summed over
+// any period in CloudWatch.
+func (m *Metrics) Datums(clusterName string) []*cloudwatch.MetricDatum {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.reported
+	if prev == nil {
+		prev = New()
+	}
+	var latency float64
+	if n := m.publishLatencyN - prev.publishLatencyN; n > 0 {
+		latency = (m.publishLatencySum - prev.publishLatencySum).Seconds() * 1000 / float64(n)
+	}
+
+	now := aws.Time(time.Now())
+	datum := func(name, unit string, value float64) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Unit:       aws.String(unit),
+			Value:      aws.Float64(value),
+			Timestamp:  now,
+			Dimensions: []*cloudwatch.Dimension{
+				&cloudwatch.Dimension{
+					Name:  aws.String("ClusterName"),
+					Value: aws.String(clusterName),
+				},
+			},
+		}
+	}
+	d := []*cloudwatch.MetricDatum{
+		datum("CollectionCycles", cloudwatch.StandardUnitCount, float64(m.collectionCycles-prev.collectionCycles)),
+		datum("MetadataErrors", cloudwatch.StandardUnitCount, float64(m.metadataErrors-prev.metadataErrors)),
+		datum("SkippedSamples", cloudwatch.StandardUnitCount, float64(m.totalSkippedSamples()-prev.totalSkippedSamples())),
+		datum("PutMetricDataSuccesses", cloudwatch.StandardUnitCount, float64(m.publishes[PublishSuccess]-prev.publishes[PublishSuccess])),
+		datum("PutMetricDataFailures", cloudwatch.StandardUnitCount, float64(m.publishes[PublishFailure]-prev.publishes[PublishFailure])),
+		datum("PutMetricDataThrottles", cloudwatch.StandardUnitCount, float64(m.publishes[PublishThrottled]-prev.publishes[PublishThrottled])),
+		datum("DatumsSent", cloudwatch.StandardUnitCount, float64(m.datumsSent-prev.datumsSent)),
+		datum("PublishLatency", cloudwatch.StandardUnitMilliseconds, latency),
+		datum("BufferedDatums", cloudwatch.StandardUnitCount, float64(m.totalBufferedDatums())),
+	}
+
+	m.reported = &Metrics{
+		collectionCycles:  m.collectionCycles,
+		metadataErrors:    m.metadataErrors,
+		publishes:         make(map[PublishResult]int64, len(m.publishes)),
+		skippedSamples:    make(map[string]int64, len(m.skippedSamples)),
+		datumsSent:        m.datumsSent,
+		publishLatencySum: m.publishLatencySum,
+		publishLatencyN:   m.publishLatencyN,
+	}
+	for k, v := range m.publishes {
+		m.reported.publishes[k] = v
+	}
+	for k, v := range m.skippedSamples {
+		m.reported.skippedSamples[k] = v
+	}
+	return d
+}
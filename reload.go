@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+)
+
+// reloadPollInterval is how often the configuration file is checked for
+// changes
+const reloadPollInterval = 5 * time.Second
+
+// reloadRequests returns a channel receiving a value on SIGHUP and whenever
+// the configuration file at path, if any, changes. Requests made while one is
+// pending are merged.
+func reloadRequests(ctx context.Context, path string) <-chan struct{} {
+	reloads := make(chan struct{}, 1)
+	request := func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hups)
+		for {
+			select {
+			case <-hups:
+				logger.Infof("SIGHUP received, reloading the configuration")
+				request()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if path != "" {
+		go watchFile(ctx, path, request)
+	}
+	return reloads
+}
+
+// watchFile calls changed whenever the file at path changes, until ctx is
+// done. It polls rather than relying on inotify, which misses the files of
+// mounted volumes being replaced.
+func watchFile(ctx context.Context, path string, changed func()) {
+	last := fileVersion(path)
+	t := time.NewTicker(reloadPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if v := fileVersion(path); v != last {
+				last = v
+				logger.With(logger.Fields{"path": path}).Infof("configuration file changed, reloading the configuration")
+				changed()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fileVersion identifies the content of the file at path by its modification
+// time and size, or returns "" if it can't be read
+func fileVersion(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// reloadConfig loads the configuration again and logs what changed from
+// current. It returns current if the new configuration is invalid.
+func reloadConfig(flags *configFlags, current *config.Config, logFields logger.Fields) *config.Config {
+	next, err := flags.load()
+	if err != nil {
+		logger.Errorf("unable to reload the configuration, keeping the current one: %v", err)
+		return current
+	}
+	keepRestartOnly(current, next)
+
+	changes := config.Diff(current, next)
+	if len(changes) == 0 {
+		logger.Infof("configuration reloaded, nothing changed")
+		return current
+	}
+	for _, change := range changes {
+		logger.Infof("configuration changed: %s", change)
+	}
+	if next.Log != current.Log {
+		// next.Log was validated by flags.load
+		initLogger(next.Log.Level, next.Log.Format)
+		logger.SetDefault(logger.With(logFields))
+	}
+	return next
+}
+
+// keepRestartOnly copies to next the settings of current which only take
+// effect on startup, warning about the ones which changed
+func keepRestartOnly(current, next *config.Config) {
+	warn := func(name string) {
+		logger.Warnf("changing %s requires a restart, keeping the current value", name)
+	}
+	if next.Mode != current.Mode || next.Daemon != current.Daemon {
+		warn("mode and daemon")
+		next.Mode, next.Daemon = current.Mode, current.Daemon
+	}
+	if next.Source != current.Source {
+		warn("source")
+		next.Source = current.Source
+	}
+	if next.Listen != current.Listen {
+		warn("listen")
+		next.Listen = current.Listen
+	}
+	if next.StartupTimeout != current.StartupTimeout {
+		warn("startupTimeout")
+		next.StartupTimeout = current.StartupTimeout
+	}
+	if !reflect.DeepEqual(next.Tags, current.Tags) {
+		// the tags are fetched once, along with the task metadata
+		warn("tags")
+		next.Tags = current.Tags
+	}
+	if next.ReadyMaxPublishAge != current.ReadyMaxPublishAge {
+		warn("readyMaxPublishAge")
+		next.ReadyMaxPublishAge = current.ReadyMaxPublishAge
+	}
+}
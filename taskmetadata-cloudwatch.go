@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"reflect"
 	"syscall"
 	"time"
 
@@ -13,106 +15,340 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cgroup"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/collector"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
 	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/cw"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/daemon"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/docker"
 	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/logger"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/server"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/telemetry"
 )
 
-const (
-	interval = 10 * time.Second
-)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(runHealthcheck(os.Args[2:]))
+	}
 
-var taskMetadata ecs.TaskResponse
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(runValidateConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "once" {
+		os.Exit(runOnce(os.Args[2:]))
+	}
 
-func main() {
-	// Wait for the Health information to be ready
-	time.Sleep(5 * time.Second)
+	flags := newConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := flags.load()
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := initLogger(cfg.Log.Level, cfg.Log.Format); err != nil {
+		fatalf("%v", err)
+	}
+
+	source := newSource(cfg)
+
+	// Cancel everything in flight, including metadata retries, on SIGINT/SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Infof("signal received: %v", sig)
+		cancel()
+	}()
+	reloads := reloadRequests(ctx, config.FilePath(flags.path))
+
+	readiness := ecs.NewReadiness(source, cfg.StartupTimeout.Duration())
+	c := collector.New(source, cfg)
+	if cfg.Listen != "" {
+		handler := server.NewHandler(server.Options{
+			Readiness:     readiness,
+			Collector:     c,
+			MaxPublishAge: cfg.ReadyMaxPublishAge.Duration(),
+		})
+		go func() {
+			if err := http.ListenAndServe(cfg.Listen, handler); err != nil {
+				logger.Errorf("unable to serve the HTTP endpoints: %v", err)
+			}
+		}()
+	}
+
+	logger.Infof("waiting for the task to be ready")
+	readinessErr := make(chan error, 1)
+	go func() {
+		readinessErr <- readiness.Run(ctx)
+	}()
+	// Start publishing as soon as we know about the task, the containers which
+	// are already running are reported while we keep waiting for the others
+	select {
+	case <-readiness.MetadataReceived():
+	case err := <-readinessErr:
+		exitOnReadinessError(err)
+		if ctx.Err() != nil {
+			logger.Infof("exiting")
+			return
+		}
+	}
+	task := readiness.Task()
+	logFields := logger.Fields{"taskArn": task.TaskARN}
+	if cfg.Mode == config.ModeDaemon {
+		logFields = logger.Fields{"containerInstanceArn": task.TaskARN}
+	}
+	logger.SetDefault(logger.With(logFields))
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	svc, err := newCloudWatch(task)
+	if err != nil {
+		fatalf("%v", err)
 	}
+	sinks, publisher := newSinks(svc, cfg, task)
 
-	containerIDToNameMap := make(map[string]string)
+	for _, con := range task.Containers {
+		if ecs.IsPauseContainer(con) {
+			logger.Infof("detected the awsvpc networking mode is enabled")
+		}
+	}
 
-	fmt.Print("waiting for the task to be ready\n")
+	ticker := time.NewTicker(cfg.Interval.Duration())
+	logger.Infof("taskmetadata-cloudwatch is up and running, awaiting termination signal")
+loop:
 	for {
-		if t, err := ecs.GetTaskMetadata(client); err != nil {
-			fmt.Fprintf(os.Stderr, "unable to get task metadata: %v\n", err)
-			os.Exit(1)
+		select {
+		case <-ticker.C:
+			telemetry.Default.IncCollectionCycles()
+			task = currentTask(ctx, source, task)
+			d, err := c.Collect(ctx, task)
+			switch {
+			case err != nil:
+				if ctx.Err() == nil {
+					logger.Errorf("unable to get task stats: %v", err)
+				}
+			case len(d) == 0:
+				logger.Infof("nothing to report for now")
+			default:
+				// TODO: Validate the data size is under `40 KB for HTTP POST requests`.
+				//  see https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/cloudwatch_limits.html
+				putData(ctx, sinks, d)
+			}
+			putSelfMetrics(ctx, publisher, task)
+		case <-reloads:
+			// Applied between two collections, so that none mixes settings
+			next := reloadConfig(flags, cfg, logFields)
+			if next == cfg {
+				continue
+			}
+			if next.Interval != cfg.Interval {
+				ticker.Stop()
+				ticker = time.NewTicker(next.Interval.Duration())
+			}
+			if sinksChanged(cfg, next) {
+				// Send what the replaced sinks buffered before dropping them
+				fctx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout.Duration())
+				flushSinks(fctx, append(sinks.All(), publisher)...)
+				cancel()
+				sinks, publisher = newSinks(svc, next, task)
+			}
+			c.SetConfig(next)
+			cfg = next
+		case err := <-readinessErr:
+			exitOnReadinessError(err)
+		case <-ctx.Done():
+			ticker.Stop()
+			break loop
+		}
+	}
+
+	shutdown(c, cfg, task, sinks, publisher)
+	logger.Infof("exiting")
+}
+
+// newSource returns the source of the metadata and stats of the configured
+// mode
+func newSource(cfg *config.Config) ecs.MetadataSource {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	if cfg.Mode == config.ModeDaemon {
+		return daemon.NewSource(ecs.NewAgentClient(cfg.Daemon.AgentURL, httpClient), docker.NewClient(cfg.Daemon.DockerSocket))
+	}
+
+	client, err := ecs.NewClientFromEnv(httpClient)
+	if err != nil {
+		fatalf("unable to create task metadata client: %v", err)
+	}
+	if cfg.Tags.Enabled() {
+		if client.Version < 4 {
+			logger.Warnf("tags require the task metadata endpoint v4, they are ignored")
 		} else {
-			taskMetadata = *t
+			client.WithTags = true
 		}
-		// Wait for the task is ready
-		if taskMetadata.KnownStatus == "RUNNING" {
-			break
+	}
+	switch cfg.Source.Type {
+	case config.SourceDocker:
+		return docker.NewSource(docker.NewClient(cfg.Source.DockerSocket), client)
+	case config.SourceCgroup:
+		return cgroup.NewSource(client, cgroup.NewReader(cfg.Source.CgroupRoot, cfg.Source.ProcRoot))
+	}
+	return client
+}
+
+// currentTask returns the task to collect the metrics of, listed again on
+// every collection: containers restart with new IDs, whose stats would be
+// dropped otherwise, the tasks of the container instance come and go in daemon
+// mode and the ephemeral storage usage is part of the metadata. last is reused
+// if that fails.
+func currentTask(ctx context.Context, source ecs.MetadataSource, last *ecs.TaskResponse) *ecs.TaskResponse {
+	task, err := source.TaskMetadata(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Errorf("unable to get the task metadata, reusing the last one: %v", err)
 		}
-		time.Sleep(time.Second)
+		return last
 	}
+	return task
+}
 
-	// init CloudWatch client
-	awsRegion := strings.Split(taskMetadata.TaskARN, ":")[3]
-	fmt.Printf("detected aws region: %v\n", awsRegion)
+// newCloudWatch returns a CloudWatch client for the region task runs in
+func newCloudWatch(task *ecs.TaskResponse) (*cloudwatch.CloudWatch, error) {
+	awsRegion := ecs.Region(task.TaskARN)
+	if awsRegion == "" {
+		return nil, fmt.Errorf("unable to detect the aws region from the task ARN '%s'", task.TaskARN)
+	}
+	logger.Infof("detected aws region: %v", awsRegion)
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(awsRegion),
 	}))
-	svc := cloudwatch.New(sess)
+	return cloudwatch.New(sess), nil
+}
 
-	// store the pause container's ID if the task is running with awsvpc networking mode
-	pauseContainerId := ""
-	for _, con := range taskMetadata.Containers {
-		if ecs.IsPauseContainer(con) {
-			pauseContainerId = con.ID
-			fmt.Print("detected the awsvpc networking mode is enabled\n")
+// newSinks returns the sinks of the container metrics of task configured in
+// cfg, for any namespace, and the sink of the sidecar's own metrics or nil if
+// they aren't published. In a dry run, the CloudWatch sinks write their
+// requests instead.
+func newSinks(svc *cloudwatch.CloudWatch, cfg *config.Config, task *ecs.TaskResponse) (*cw.Router, cw.Sink) {
+	newCloudWatchSink := func(namespace string, maxBuffered int) cw.Sink {
+		if cfg.DryRun.Enabled {
+			return cw.NewDryRunSink(namespace, cfg.DryRun.Path, cfg.Interval.Duration())
 		}
-		containerIDToNameMap[con.ID] = con.DockerName
+		return cw.NewBufferedSink(svc, namespace, maxBuffered)
 	}
+	sinks := cw.NewRouter(func(namespace string) []cw.Sink {
+		var sinks []cw.Sink
+		for _, s := range cfg.Sinks {
+			switch s.Type {
+			case config.SinkCloudWatch:
+				sinks = append(sinks, newCloudWatchSink(namespace, s.MaxBuffered))
+			case config.SinkEMF:
+				sinks = append(sinks, cw.NewEMFSink(namespace, os.Stdout, collector.TagProperties(cfg, task)))
+			}
+		}
+		return sinks
+	})
+	var publisher cw.Sink
+	if cfg.SelfMetrics {
+		publisher = newCloudWatchSink(cfg.PublisherNamespace(), 1000)
+	}
+	return sinks, publisher
+}
 
-	sigs := make(chan os.Signal, 1)
-	quit := make(chan bool, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+// sinksChanged returns true if the sinks must be created again to apply next
+func sinksChanged(current, next *config.Config) bool {
+	return next.Namespace != current.Namespace ||
+		next.SelfMetrics != current.SelfMetrics ||
+		next.DryRun != current.DryRun ||
+		// The dry run estimates the cost of a request made every interval
+		(next.DryRun.Enabled && next.Interval != current.Interval) ||
+		!reflect.DeepEqual(next.Sinks, current.Sinks)
+}
 
-	ticker := time.NewTicker(interval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				if taskStats, err := ecs.GetTaskStats(client); err != nil {
-					fmt.Fprintf(os.Stderr, "unable to get task stats: %v\n", err)
-				} else {
-					var d []*cloudwatch.MetricDatum
-					clusterName := taskMetadata.Cluster
-					var containerName string
-					for key, conStats := range taskStats {
-						// We ignore the CNI pause container's metrics or a no-stats container
-						if key == pauseContainerId || conStats == nil {
-							continue
-						}
-						containerName = containerIDToNameMap[key]
-						if data, _ := cw.GetMemoryUtilization(conStats, clusterName, containerName); data != nil {
-							d = append(d, data)
-						}
-						if data, _ := cw.GetCpuUtilization(conStats, clusterName, containerName); data != nil {
-							d = append(d, data)
-						}
-					}
-					if len(d) == 0 {
-						fmt.Print("nothing to report for now\n")
-						continue
-					}
-					// TODO: Validate the data size is under `40 KB for HTTP POST requests`.
-					//  see https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/cloudwatch_limits.html
-					if err := cw.PutMetrics(svc, d...); err != nil {
-						fmt.Fprintf(os.Stderr, "unable to put metrics: err [%v]\n", err)
-					}
-				}
-			case sig := <-sigs:
-				fmt.Printf("signal recieved: %d\n", sig)
-				ticker.Stop()
-				quit <- true
-				return
-			}
+// flushSinks sends what sinks buffered, nil sinks are skipped
+func flushSinks(ctx context.Context, sinks ...cw.Sink) {
+	for _, sink := range sinks {
+		if sink == nil {
+			continue
 		}
-	}()
-	fmt.Printf("taskmetadata-cloudwatch is up and running, awaiting termination signal\n")
-	<-quit
-	fmt.Printf("exiting")
+		if err := sink.Flush(ctx); err != nil {
+			logger.With(logger.Fields{"sink": sink.Name()}).Errorf("unable to flush metrics: %v", err)
+		}
+	}
+}
+
+// shutdown collects one final sample, so that the interval the task stopped in
+// isn't lost, then flushes it to every sink along with the task-stopping
+// marker within the configured timeout
+func shutdown(c *collector.Collector, cfg *config.Config, task *ecs.TaskResponse, sinks *cw.Router, publisher cw.Sink) {
+	timeout := cfg.ShutdownTimeout.Duration()
+	logger.Infof("flushing metrics within %v", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	d, err := c.Collect(ctx, task)
+	if err != nil {
+		logger.Errorf("unable to get the final task stats: %v", err)
+		d = make(collector.Data)
+	}
+	// In daemon mode, the instance rather than a task is stopping
+	if cfg.Mode != config.ModeDaemon {
+		d[cfg.Namespace] = append(d[cfg.Namespace], cw.GetTaskStopping(collector.TaskDimensions(cfg, task)))
+	}
+	putData(ctx, sinks, d)
+	flushSinks(ctx, sinks.All()...)
+	putSelfMetrics(ctx, publisher, task)
+}
+
+// putSelfMetrics puts the sidecar's own metrics to publisher, if enabled
+func putSelfMetrics(ctx context.Context, publisher cw.Sink, task *ecs.TaskResponse) {
+	if publisher == nil {
+		return
+	}
+	putMetrics(ctx, []cw.Sink{publisher}, telemetry.Default.Datums(task.Cluster))
+}
+
+// putData puts every namespace of d to its sinks
+func putData(ctx context.Context, sinks *cw.Router, d collector.Data) {
+	for namespace, datums := range d {
+		putMetrics(ctx, sinks.Sinks(namespace), datums)
+	}
+}
+
+func putMetrics(ctx context.Context, sinks []cw.Sink, d []*cloudwatch.MetricDatum) {
+	for _, sink := range sinks {
+		if err := sink.Put(ctx, d...); err != nil {
+			logger.With(logger.Fields{"sink": sink.Name()}).Errorf("unable to put metrics: %v", err)
+		}
+	}
+}
+
+// exitOnReadinessError exits the process if the task didn't become ready. err
+// is nil once the task is RUNNING, and context.Canceled when shutting down.
+func exitOnReadinessError(err error) {
+	if err == nil || err == context.Canceled {
+		return
+	}
+	fatalf("unable to wait for the task to be ready: %v", err)
+}
+
+func initLogger(level, format string) error {
+	l, err := logger.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	f, err := logger.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	logger.SetDefault(logger.New(os.Stderr, l, f))
+	return nil
+}
+
+// fatalf logs an error then exits the process
+func fatalf(format string, args ...interface{}) {
+	logger.Errorf(format, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/docker/docker/api/types"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/collector"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/ecs"
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/fakeecs"
+)
+
+// TestCurrentTaskFollowsRestarts collects the way the main loop does, the
+// task coming from the readiness check then from currentTask
+func TestCurrentTaskFollowsRestarts(t *testing.T) {
+	server := httptest.NewServer(fakeecs.NewServer(fakeecs.Restarting()))
+	defer server.Close()
+	client := ecs.NewClient(server.URL+"/v4/fake", http.DefaultClient)
+	client.Version = 4
+	cfg := config.Default()
+	ctx := context.Background()
+
+	readiness := ecs.NewReadiness(client, 0)
+	if err := readiness.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	task := readiness.Task()
+	c := collector.New(client, cfg)
+	// The application container restarts with a new ID every 6 samples
+	for i := 0; i < 9; i++ {
+		task = currentTask(ctx, client, task)
+		d, err := c.Collect(ctx, task)
+		if err != nil {
+			t.Fatalf("sample %d: %v", i, err)
+		}
+		if !hasMetric(d[cfg.Namespace], "MemoryUtilization", "ecs-fake-app-1-app") {
+			t.Errorf("sample %d: no MemoryUtilization for the application container", i)
+		}
+	}
+}
+
+// failingSource is an ecs.MetadataSource whose metadata is unavailable
+type failingSource struct{}
+
+func (failingSource) TaskMetadata(context.Context) (*ecs.TaskResponse, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingSource) TaskStats(context.Context) (map[string]*types.Stats, error) {
+	return nil, nil
+}
+
+func TestCurrentTaskReusesLast(t *testing.T) {
+	last := fakeecs.Steady().Task(0)
+	if got := currentTask(context.Background(), failingSource{}, last); got != last {
+		t.Errorf("got %+v, want the last task", got)
+	}
+}
+
+func hasMetric(datums []*cloudwatch.MetricDatum, metric, container string) bool {
+	for _, d := range datums {
+		if aws.StringValue(d.MetricName) != metric {
+			continue
+		}
+		for _, dim := range d.Dimensions {
+			if aws.StringValue(dim.Name) == config.DimensionContainerName && aws.StringValue(dim.Value) == container {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestNewCloudWatchInvalidARN(t *testing.T) {
+	for _, arn := range []string{"", "0123456789abcdef", "arn:aws:ecs"} {
+		if _, err := newCloudWatch(&ecs.TaskResponse{TaskARN: arn}); err == nil {
+			t.Errorf("%q: got no error", arn)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/toricls/ecs-taskmetadata-cloudwatch/pkg/config"
+)
+
+// runValidateConfig implements the validate-config command and returns the
+// exit code
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	path := fs.String("config", "",
+		"path of the JSON or YAML configuration file, defaults to $"+config.FileEnvVar+" then the content of $"+config.InlineEnvVar)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate-config [-config path | path]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// The path may be given as an argument as well
+	switch {
+	case fs.NArg() > 1 || (fs.NArg() == 1 && *path != ""):
+		fmt.Fprintln(os.Stderr, "validate-config takes a single configuration path")
+		fs.Usage()
+		return 1
+	case fs.NArg() == 1:
+		*path = fs.Arg(0)
+	}
+
+	data, source, err := config.Read(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cfg := config.Default()
+	if data == nil {
+		fmt.Println("no configuration set, the defaults are used:")
+	} else if cfg, err = config.Parse(data, source); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	} else {
+		fmt.Printf("%s is valid, the effective configuration is:\n", source)
+	}
+	b, _ := json.MarshalIndent(cfg, "", "  ")
+	fmt.Println(string(b))
+	return 0
+}